@@ -0,0 +1,166 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	reCargoDependencyTable = regexp.MustCompile(`^(?:workspace\.|target\.[^\[\]]+\.)?(?:dependencies|dev-dependencies|build-dependencies)$`)
+	reCargoNonRegistry     = regexp.MustCompile(`\b(?:path|git|registry|workspace)\s*=`)
+	reCargoPackage         = regexp.MustCompile(`\bpackage\s*=\s*"([^"]*)"`)
+)
+
+// cargoCratesIOSource is the source of crates.io packages in a Cargo.lock, even when they are fetched with the sparse protocol.
+// Alternative registries have a registry+ source too, but their crates aren't on crates.io.
+const cargoCratesIOSource = "registry+https://github.com/rust-lang/crates.io-index"
+
+// cargoSpecVersion returns the lowest version a Cargo version requirement allows, e.g. 1.2.0 for 1.2, ^1.2 or >= 1.2, < 1.5.
+// Returns false for requirements without a lower bound, such as *.
+// See https://doc.rust-lang.org/cargo/reference/specifying-dependencies.html
+func cargoSpecVersion(spec string) (string, bool) {
+	if strings.TrimSpace(spec) == "" {
+		return "", false
+	}
+	// A bare version is a caret requirement, which npm ranges have the same lower bound for
+	r, err := parseNpmRange(strings.Replace(spec, ",", " ", -1))
+	if err != nil {
+		return "", false
+	}
+	floor, ok := r.floor()
+	return floor.String(), ok
+}
+
+// cargoLineAllows checks whether the version requirement of a Cargo.toml line allows the version, e.g. not 1.6.0 for ">= 1.6.0, < 1.5"
+func cargoLineAllows(line, version string) bool {
+	_, value, ok := tomlKeyValue(line)
+	if !ok {
+		return false
+	}
+	r, err := parseNpmRange(strings.Replace(tomlSpec(value), ",", " ", -1))
+	if err != nil {
+		return false
+	}
+	v, ok := parseSemver(version)
+	return ok && r.satisfies(v)
+}
+
+// cargoDependency creates the component for a dependency given as a version requirement or an inline table.
+// Returns false for dependencies which aren't from crates.io, e.g. path, git, alternative registry or workspace dependencies.
+func cargoDependency(name, value string) (component, bool) {
+	if strings.HasPrefix(value, "{") {
+		if reCargoNonRegistry.MatchString(value) {
+			return component{}, false
+		}
+		// Renamed dependencies give the crate's name as package
+		if m := reCargoPackage.FindStringSubmatch(value); m != nil {
+			name = m[1]
+		}
+	}
+	version, ok := cargoSpecVersion(tomlSpec(value))
+	if !ok {
+		return component{}, false
+	}
+	return component{format: "cargo", name: name, version: version}, true
+}
+
+// componentsFromCargoToml finds the dependencies of a Cargo.toml's [dependencies], [dev-dependencies], [build-dependencies]
+// and [workspace.dependencies] tables, including those of target specific tables and those with a table of their own, e.g. [dependencies.serde]
+func componentsFromCargoToml(lines []patchLine) map[int64]component {
+	found := make(map[int64]component)
+
+	// A dependency with a table of its own is only known once the whole table was read, as its package may follow its version
+	var (
+		crate   string
+		fields  []string
+		version int64
+	)
+	flush := func() {
+		if crate != "" && version != 0 {
+			if c, ok := cargoDependency(crate, "{"+strings.Join(fields, ", ")+"}"); ok {
+				found[version] = c
+			}
+		}
+		crate, fields, version = "", nil, 0
+	}
+
+	var table string
+	for _, l := range lines {
+		if t, ok := tomlTable(l.text); ok {
+			flush()
+			table = t
+			if i := strings.LastIndex(t, "."); i > 0 && !reCargoDependencyTable.MatchString(t) && reCargoDependencyTable.MatchString(t[:i]) {
+				crate = t[i+1:]
+			}
+			continue
+		}
+		key, value, ok := tomlKeyValue(l.text)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case crate != "":
+			fields = append(fields, key+" = "+value)
+			if key == "version" {
+				version = l.location.Line
+			}
+		case reCargoDependencyTable.MatchString(table):
+			// Dotted keys set a dependency's fields one by one, e.g. serde.version = "1.0"
+			if i := strings.Index(key, "."); i > 0 {
+				if key[i+1:] != "version" {
+					continue
+				}
+				key, value = key[:i], "{version = "+value+"}"
+			}
+			if c, ok := cargoDependency(key, value); ok {
+				found[l.location.Line] = c
+			}
+		}
+	}
+	flush()
+
+	return found
+}
+
+// componentsFromCargoLock finds the packages of a Cargo.lock which are from crates.io, reported on their version line.
+// Packages without a source are the workspace's own crates. Without the whole file, packages whose [[package]] header
+// isn't visible are kept unless their source is visible and isn't crates.io.
+func componentsFromCargoLock(lines []patchLine) map[int64]component {
+	found := make(map[int64]component)
+
+	var (
+		name, source string
+		version      lineValue
+		complete     bool
+	)
+	flush := func() {
+		if name != "" && version.text != "" && (source == cargoCratesIOSource || (!complete && source == "")) {
+			found[version.line] = component{format: "cargo", name: name, version: version.text}
+		}
+		name, source, version, complete = "", "", lineValue{}, false
+	}
+
+	for _, l := range lines {
+		if t, ok := tomlTable(l.text); ok {
+			flush()
+			complete = t == "package"
+			continue
+		}
+		key, value, ok := tomlKeyValue(l.text)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "name":
+			name = tomlSpec(value)
+		case "version":
+			version = lineValue{tomlSpec(value), l.location.Line}
+		case "source":
+			source = tomlSpec(value)
+		}
+	}
+	flush()
+
+	return found
+}
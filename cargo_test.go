@@ -0,0 +1,168 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testCargoToml = `[package]
+name = "service"
+version = "0.1.0"
+edition = "2021"
+
+[dependencies]
+serde = "1.0"
+serde_json = { version = "1.0.70", features = ["preserve_order"] }
+tokio = { workspace = true }
+common = { path = "../common" }
+forked = { git = "https://github.com/example/forked" }
+rand_old = { package = "rand", version = "=0.7.3" }
+anything = "*"
+log.version = "0.4.14"
+
+[dependencies.reqwest]
+version = ">= 0.11.6, < 0.12"
+default-features = false
+
+[dev-dependencies]
+mockito = "~0.30"
+
+[target.'cfg(unix)'.dependencies]
+nix = "0.23.0"
+
+[workspace.dependencies]
+tokio = { version = "1.14.0", features = ["full"] }`
+
+const testCargoLock = `# This file is automatically @generated by Cargo.
+version = 3
+
+[[package]]
+name = "serde"
+version = "1.0.130"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+checksum = "f12d06de37cf59146fbdecab66aa99f9fe4f78722e3607577a5375d66bd0c913"
+
+[[package]]
+name = "service"
+version = "0.1.0"
+dependencies = [
+ "serde",
+]
+
+[[package]]
+name = "forked"
+version = "0.2.0"
+source = "git+https://github.com/example/forked#0cd72a56f984ef7b6dd7e3c5e9ae6cc8b4b8a1e0"
+
+[[package]]
+name = "internal"
+version = "1.2.0"
+source = "registry+https://git.example.com/rust/crates-index"
+checksum = "7a2b7c6c4d0b3a5c1e9f2d6e8a4b1c3d5e7f9a0b2c4d6e8f0a1b3c5d7e9f1a2b"`
+
+func Test_cargoManifests(t *testing.T) {
+	cargo := func(name, version string) component {
+		return component{format: "cargo", name: name, version: version}
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		added    []int
+		want     map[int64]component
+	}{
+		{
+			"dependencies",
+			"Cargo.toml", testCargoToml, []int{3, 7, 8, 9, 10, 11, 12, 13, 14},
+			map[int64]component{
+				7:  cargo("serde", "1.0.0"),
+				8:  cargo("serde_json", "1.0.70"),
+				12: cargo("rand", "0.7.3"),
+				14: cargo("log", "0.4.14"),
+			},
+		},
+		{
+			"dependency table",
+			"crates/service/Cargo.toml", testCargoToml, []int{16, 17, 18},
+			map[int64]component{17: cargo("reqwest", "0.11.6")},
+		},
+		{
+			"dev, target and workspace dependencies",
+			"Cargo.toml", testCargoToml, []int{21, 24, 27},
+			map[int64]component{
+				21: cargo("mockito", "0.30.0"),
+				24: cargo("nix", "0.23.0"),
+				27: cargo("tokio", "1.14.0"),
+			},
+		},
+		{
+			"Cargo.lock",
+			"Cargo.lock", testCargoLock, []int{2, 6, 12, 19, 24},
+			map[int64]component{6: cargo("serde", "1.0.130")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := func(string) (string, error) { return tt.content, nil }
+			checkAddedComponents(t, tt.filename, tt.content, tt.added, manifestSource{content: content}, tt.want)
+		})
+	}
+}
+
+func Test_componentsFromCargoLock_patchOnly(t *testing.T) {
+	patch := `@@ -4,8 +4,8 @@ version = 3
+ [[package]]
+ name = "serde"
+-version = "1.0.129"
++version = "1.0.130"
+ source = "registry+https://github.com/rust-lang/crates.io-index"
+ checksum = "f12d06de37cf59146fbdecab66aa99f9fe4f78722e3607577a5375d66bd0c913"
+@@ -20,4 +20,4 @@ dependencies = [
+ name = "tokio"
+-version = "1.13.0"
++version = "1.14.0"
+ source = "registry+https://github.com/rust-lang/crates.io-index"`
+	want := map[changeLocation]component{
+		changeLocation{Position: 4, Line: 6}:   component{format: "cargo", name: "serde", version: "1.0.130"},
+		changeLocation{Position: 10, Line: 21}: component{format: "cargo", name: "tokio", version: "1.14.0"},
+	}
+
+	got, err := manifestParserFor("Cargo.lock")(changedFile{Filename: "Cargo.lock", Patch: patch}, manifestSource{})
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("parse()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+}
+
+func Test_suggestCargoLine(t *testing.T) {
+	tests := []struct {
+		name                       string
+		line, original, remediated string
+		want                       string
+	}{
+		{"version", `nix = "0.23.0"`, "0.23.0", "0.24.1", `nix = "0.24.1"`},
+		{"exact", `rand_old = { package = "rand", version = "=0.7.3" }`, "0.7.3", "0.8.5", `rand_old = { package = "rand", version = "=0.8.5" }`},
+		{"range still allows the version", `version = ">= 1.2.0, < 2"`, "1.2.0", "1.6.0", `version = ">= 1.6.0, < 2"`},
+		{"upper bound below the version", `version = ">= 1.2.0, < 1.5"`, "1.2.0", "1.6.0", ""},
+		{"dotted key", `log.version = ">=0.4.14, <0.4.17"`, "0.4.14", "0.4.20", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestAllowedLine(tt.line, tt.original, tt.remediated, cargoLineAllows); got != tt.want {
+				t.Errorf("suggestAllowedLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cargoPurl(t *testing.T) {
+	c := component{format: "cargo", name: "serde", version: "1.0.130"}
+	if got, want := c.purl(), "pkg:cargo/serde@1.0.130"; got != want {
+		t.Errorf("purl() = %q, want %q", got, want)
+	}
+}
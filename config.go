@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envOrDefault returns the value of the environment variable or the default if it is not set
+func envOrDefault(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// repoConfig holds the IQ and SCM credentials used when remediating a repository
+type repoConfig struct {
+	IQURL      string `json:"iq_url,omitempty"`
+	IQUsername string `json:"iq_username,omitempty"`
+	IQPassword string `json:"iq_password,omitempty"`
+	IQApp      string `json:"iq_app,omitempty"`
+	Token      string `json:"token,omitempty"`
+
+	// IQApps evaluates the manifests under a directory against a different IQ application than IQApp
+	IQApps map[string]string `json:"iq_apps,omitempty"`
+
+	// NpmSections are the package.json sections whose dependencies are reviewed, e.g. ["dependencies"]
+	NpmSections []string `json:"npm_sections,omitempty"`
+}
+
+// merge returns a copy of the config with any fields set in other overriding its own
+func (c repoConfig) merge(other repoConfig) repoConfig {
+	override := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	override(&c.IQURL, other.IQURL)
+	override(&c.IQUsername, other.IQUsername)
+	override(&c.IQPassword, other.IQPassword)
+	override(&c.IQApp, other.IQApp)
+	override(&c.Token, other.Token)
+	if len(other.IQApps) > 0 {
+		apps := make(map[string]string, len(c.IQApps)+len(other.IQApps))
+		for dir, app := range c.IQApps {
+			apps[dir] = app
+		}
+		for dir, app := range other.IQApps {
+			apps[dir] = app
+		}
+		c.IQApps = apps
+	}
+	if len(other.NpmSections) > 0 {
+		c.NpmSections = other.NpmSections
+	}
+	return c
+}
+
+// iqApplications returns the IQ application to evaluate each directory's manifests against
+func (c repoConfig) iqApplications() iqApplications {
+	return newIQApplications(c.IQApp, c.IQApps)
+}
+
+// npmSections returns the package.json sections to review, defaulting to every kind of dependency
+func (c repoConfig) npmSections() []string {
+	if len(c.NpmSections) > 0 {
+		return c.NpmSections
+	}
+	return defaultNpmSections
+}
+
+// config holds the credentials and webhook secrets for every repository this deployment handles.
+// The embedded repoConfig is the default for repositories without their own entry.
+type config struct {
+	repoConfig
+	GithubApp            githubApp             `json:"github_app,omitempty"`
+	Hosts                map[string]scmHost    `json:"hosts,omitempty"`
+	Repositories         map[string]repoConfig `json:"repositories,omitempty"`
+	GithubWebhookSecret  string                `json:"github_webhook_secret,omitempty"`
+	GitlabWebhookSecrets gitlabWebhookSecrets  `json:"gitlab_webhook_secrets,omitempty"`
+	LegacyQueryParams    bool                  `json:"legacy_query_params,omitempty"`
+	// AllowUnverifiedWebhooks processes webhooks when no secret is configured to verify them, which lets anyone trigger reviews
+	AllowUnverifiedWebhooks bool `json:"allow_unverified_webhooks,omitempty"`
+}
+
+// merge layers the given config on top of this one
+func (c *config) merge(other config) {
+	c.repoConfig = c.repoConfig.merge(other.repoConfig)
+	for name, host := range other.Hosts {
+		if c.Hosts == nil {
+			c.Hosts = make(map[string]scmHost)
+		}
+		merged := c.Hosts[name]
+		merged.repoConfig = merged.repoConfig.merge(host.repoConfig)
+		if host.APIURL != "" {
+			merged.APIURL = host.APIURL
+		}
+		if host.CABundle != "" {
+			merged.CABundle = host.CABundle
+		}
+		merged.GithubApp = merged.GithubApp.merge(host.GithubApp)
+		c.Hosts[name] = merged
+	}
+	for name, repo := range other.Repositories {
+		if c.Repositories == nil {
+			c.Repositories = make(map[string]repoConfig)
+		}
+		c.Repositories[name] = c.Repositories[name].merge(repo)
+	}
+	c.GithubApp = c.GithubApp.merge(other.GithubApp)
+	if other.GithubWebhookSecret != "" {
+		c.GithubWebhookSecret = other.GithubWebhookSecret
+	}
+	for project, tokens := range other.GitlabWebhookSecrets {
+		if c.GitlabWebhookSecrets == nil {
+			c.GitlabWebhookSecrets = make(gitlabWebhookSecrets)
+		}
+		c.GitlabWebhookSecrets[project] = tokens
+	}
+	c.LegacyQueryParams = c.LegacyQueryParams || other.LegacyQueryParams
+	c.AllowUnverifiedWebhooks = c.AllowUnverifiedWebhooks || other.AllowUnverifiedWebhooks
+}
+
+// forRepository returns the credentials for the given repository, e.g. "owner/repo" or "group/project".
+// Repositories can be configured by name, or by host and name when several hosts have the same repository names.
+// Repositories on hosts which aren't trusted get no token.
+func (c config) forRepository(host, name string) repoConfig {
+	repo := c.repoConfig.
+		merge(c.Hosts[host].repoConfig).
+		merge(c.Repositories[name]).
+		merge(c.Repositories[host+"/"+name])
+	if !c.trustsHost(host) {
+		repo.Token = ""
+	}
+	return repo
+}
+
+// fromQuery applies credentials passed as webhook query parameters.
+// Only honored when the deployment has opted into the legacy behavior.
+func (c config) fromQuery(repo repoConfig, query map[string]string) repoConfig {
+	if !c.LegacyQueryParams {
+		for _, p := range []string{"token", "iq_url", "iq_auth"} {
+			if _, ok := query[p]; ok {
+				log.Printf("WARN: ignoring %s query parameter as legacy query parameters are not enabled\n", p)
+			}
+		}
+		return repo
+	}
+
+	legacy := repoConfig{
+		IQURL: query["iq_url"],
+		IQApp: query["iq_app"],
+		Token: query["token"],
+	}
+	if auth := strings.SplitN(query["iq_auth"], ":", 2); len(auth) == 2 {
+		legacy.IQUsername, legacy.IQPassword = auth[0], auth[1]
+	}
+
+	return repo.merge(legacy)
+}
+
+func configFromJSON(buf []byte) (config, error) {
+	var c config
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return config{}, fmt.Errorf("could not unmarshal config as json: %v", err)
+	}
+	return c, nil
+}
+
+func configFromFile(path string) (config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config{}, fmt.Errorf("could not read config file: %v", err)
+	}
+	return configFromJSON(buf)
+}
+
+func configFromSecret(store secretStore, name string) (config, error) {
+	secret, err := store.GetSecret(name)
+	if err != nil {
+		return config{}, fmt.Errorf("could not retrieve config secret %s: %v", name, err)
+	}
+	return configFromJSON([]byte(secret))
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func configFromEnv() config {
+	legacy, _ := strconv.ParseBool(os.Getenv("LEGACY_QUERY_PARAMS"))
+	unverified, _ := strconv.ParseBool(os.Getenv("ALLOW_UNVERIFIED_WEBHOOKS"))
+	appID, _ := strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64)
+	return config{
+		repoConfig: repoConfig{
+			IQURL:       os.Getenv("IQ_URL"),
+			IQUsername:  os.Getenv("IQ_USERNAME"),
+			IQPassword:  os.Getenv("IQ_PASSWORD"),
+			IQApp:       os.Getenv("IQ_APP"),
+			Token:       os.Getenv("SCM_TOKEN"),
+			NpmSections: splitList(os.Getenv("NPM_SECTIONS")),
+		},
+		GithubApp: githubApp{
+			AppID:          appID,
+			PrivateKey:     os.Getenv("GITHUB_APP_PRIVATE_KEY"),
+			PrivateKeyFile: os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE"),
+		},
+		GithubWebhookSecret:     os.Getenv("GITHUB_WEBHOOK_SECRET"),
+		GitlabWebhookSecrets:    parseGitlabWebhookSecrets(os.Getenv("GITLAB_WEBHOOK_SECRETS")),
+		LegacyQueryParams:       legacy,
+		AllowUnverifiedWebhooks: unverified,
+	}
+}
+
+// loadConfig builds the configuration from, in increasing order of precedence,
+// the file at CONFIG_FILE, the secret named by CONFIG_SECRET_ID and environment variables
+func loadConfig() (config, error) {
+	var c config
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		fileConfig, err := configFromFile(path)
+		if err != nil {
+			return config{}, err
+		}
+		c.merge(fileConfig)
+	}
+
+	if name := os.Getenv("CONFIG_SECRET_ID"); name != "" {
+		store, err := newSecretStore(envOrDefault("CONFIG_SECRET_SOURCE", "secretsmanager"))
+		if err != nil {
+			return config{}, err
+		}
+		secretConfig, err := configFromSecret(store, name)
+		if err != nil {
+			return config{}, err
+		}
+		c.merge(secretConfig)
+	}
+
+	c.merge(configFromEnv())
+
+	return c, nil
+}
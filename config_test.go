@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testConfigJSON = `{
+	"iq_url": "http://iq:8070",
+	"iq_username": "admin",
+	"iq_password": "admin123",
+	"iq_app": "default-app",
+	"token": "default-token",
+	"github_webhook_secret": "gh-secret",
+	"gitlab_webhook_secrets": {"group/project": ["new", "old"]},
+	"repositories": {
+		"owner/repo": {"iq_app": "repo-app", "token": "repo-token"}
+	}
+}`
+
+func Test_configFromSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "remediations"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "remediations", "config"), []byte(testConfigJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := configFromSecret(fileSecretStore{dir: dir}, "remediations/config")
+	if err != nil {
+		t.Fatalf("configFromSecret() error = %v", err)
+	}
+
+	want := config{
+		repoConfig: repoConfig{
+			IQURL:      "http://iq:8070",
+			IQUsername: "admin",
+			IQPassword: "admin123",
+			IQApp:      "default-app",
+			Token:      "default-token",
+		},
+		Repositories: map[string]repoConfig{
+			"owner/repo": {IQApp: "repo-app", Token: "repo-token"},
+		},
+		GithubWebhookSecret:  "gh-secret",
+		GitlabWebhookSecrets: gitlabWebhookSecrets{"group/project": {"new", "old"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("configFromSecret() = %+v, want %+v", got, want)
+	}
+
+	if _, err := configFromSecret(fileSecretStore{dir: dir}, "missing"); err == nil {
+		t.Error("configFromSecret() expected error for missing secret")
+	}
+}
+
+func Test_config_forRepository(t *testing.T) {
+	cfg, err := configFromJSON([]byte(testConfigJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		repo  string
+		query map[string]string
+		cfg   config
+		want  repoConfig
+	}{
+		{
+			"default",
+			"other/repo",
+			nil,
+			cfg,
+			repoConfig{IQURL: "http://iq:8070", IQUsername: "admin", IQPassword: "admin123", IQApp: "default-app", Token: "default-token"},
+		},
+		{
+			"repository override",
+			"owner/repo",
+			nil,
+			cfg,
+			repoConfig{IQURL: "http://iq:8070", IQUsername: "admin", IQPassword: "admin123", IQApp: "repo-app", Token: "repo-token"},
+		},
+		{
+			"query ignored",
+			"owner/repo",
+			map[string]string{"token": "query-token", "iq_auth": "user:pa:ss"},
+			cfg,
+			repoConfig{IQURL: "http://iq:8070", IQUsername: "admin", IQPassword: "admin123", IQApp: "repo-app", Token: "repo-token"},
+		},
+		{
+			"legacy query",
+			"owner/repo",
+			map[string]string{"token": "query-token", "iq_auth": "user:pa:ss", "iq_url": "http://other:8070"},
+			func() config { c := cfg; c.LegacyQueryParams = true; return c }(),
+			repoConfig{IQURL: "http://other:8070", IQUsername: "user", IQPassword: "pa:ss", IQApp: "repo-app", Token: "query-token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.fromQuery(tt.cfg.forRepository("github.com", tt.repo), tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forRepository() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfigJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "overrides"), []byte(`{"iq_app": "secret-app"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("CONFIG_SECRET_ID", "overrides")
+	t.Setenv("CONFIG_SECRET_SOURCE", "file")
+	t.Setenv("CONFIG_SECRETS_DIR", dir)
+	t.Setenv("SCM_TOKEN", "env-token")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	want := repoConfig{IQURL: "http://iq:8070", IQUsername: "admin", IQPassword: "admin123", IQApp: "secret-app", Token: "env-token"}
+	if got := cfg.forRepository("github.com", "other/repo"); !reflect.DeepEqual(got, want) {
+		t.Errorf("loadConfig() = %+v, want %+v", got, want)
+	}
+}
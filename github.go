@@ -2,6 +2,10 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,18 +13,27 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
 
 	nexusiq "github.com/sonatype-nexus-community/gonexus/iq"
 )
 
 // GithubPullRequest defines the structure of a Github pull request
 type GithubPullRequest struct {
-	Action      string      `json:"action"`
-	Number      int64       `json:"number"`
-	PullRequest pullRequest `json:"pull_request"`
-	Repository  repo        `json:"repository"`
-	Sender      githubUser  `json:"sender"`
+	Action       string       `json:"action"`
+	Number       int64        `json:"number"`
+	Before       string       `json:"before,omitempty"`
+	After        string       `json:"after,omitempty"`
+	PullRequest  pullRequest  `json:"pull_request"`
+	Repository   repo         `json:"repository"`
+	Sender       githubUser   `json:"sender"`
+	Installation installation `json:"installation,omitempty"`
+}
+
+type installation struct {
+	ID     int64  `json:"id"`
+	NodeID string `json:"node_id"`
 }
 
 type pullRequest struct {
@@ -214,6 +227,25 @@ type githubPullRequestFile struct {
 	Patch       string `json:"patch"`
 }
 
+// GET /repos/:owner/:repo/compare/:base...:head
+type githubCompare struct {
+	Files []githubPullRequestFile `json:"files"`
+}
+
+// GET /repos/:owner/:repo/contents/:path
+type githubContent struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+// GET /repos/:owner/:repo/pulls/:pull_number/comments
+type githubPullRequestComment struct {
+	Path string `json:"path"`
+	// Line is the line of the file the comment is on, which is null once the line is outdated
+	Line int64  `json:"line"`
+	Body string `json:"body"`
+}
+
 // POST /repos/:owner/:repo/pulls/:pull_number/comments
 type githubPullRequestCommentSinglelineRequest struct {
 	CommitID string `json:"commit_id"`
@@ -224,63 +256,263 @@ type githubPullRequestCommentSinglelineRequest struct {
 }
 
 func getGitHubEventType(requestHeaders map[string]string) (string, error) {
-	eventType, ok := requestHeaders["X-GitHub-Event"]
+	eventType, ok := getHeader(requestHeaders, "X-GitHub-Event")
 	if !ok {
 		return "", errors.New("error: did not receive a github event")
 	}
 	return eventType, nil
 }
 
-func ghreq(method, url, token string, payload io.Reader) (*http.Response, error) {
-	log.Printf("TRACE: req(%s, %s, %s, payload)", method, url, token)
+// verifyGithubWebhookSignature checks the X-Hub-Signature-256 header is the HMAC of the payload using the webhook secret
+func verifyGithubWebhookSignature(requestHeaders map[string]string, payload []byte, secret string) error {
+	signature, ok := getHeader(requestHeaders, "X-Hub-Signature-256")
+	if !ok || signature == "" {
+		return errors.New("did not receive a webhook signature")
+	}
+
+	const prefix = "sha256="
+	if !strings.HasPrefix(signature, prefix) {
+		return fmt.Errorf("unsupported webhook signature format: %s", signature)
+	}
+
+	got, err := hex.DecodeString(signature[len(prefix):])
+	if err != nil {
+		return fmt.Errorf("could not decode webhook signature: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("webhook signature does not match payload")
+	}
+
+	return nil
+}
+
+func ghreq(method, url string, scm scmConnection, payload io.Reader) (*http.Response, error) {
+	log.Printf("TRACE: req(%s, %s, payload)", method, url)
 	request, err := http.NewRequest(method, url, payload)
 	if err != nil {
 		return nil, fmt.Errorf("could not create HTTP request: %v", err)
 	}
 
-	request.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+	request.Header.Set("Authorization", fmt.Sprintf("token %s", scm.token))
 
 	if payload != nil {
 		request.Header.Set("Content-Type", "application/json")
 	}
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+	return scm.client.Do(request)
+}
+
+// githubRepoURL returns the API URL of a repository, e.g. https://api.github.com/repos/owner/repo.
+// Endpoints are built from the configured API rather than the URLs in the payload, so that they are always on a trusted server.
+func githubRepoURL(scm scmConnection, fullName string) string {
+	segments := strings.Split(fullName, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return fmt.Sprintf("%s/repos/%s", scm.apiURL, strings.Join(segments, "/"))
+}
+
+// githubPullURL returns the API URL of the pull request, e.g. https://api.github.com/repos/owner/repo/pulls/1
+func githubPullURL(scm scmConnection, pull GithubPullRequest) string {
+	return fmt.Sprintf("%s/pulls/%d", githubRepoURL(scm, pull.Repository.FullName), pull.PullRequest.Number)
+}
+
+// GitHub only lists up to this many files of a pull request
+const githubMaxPullRequestFiles = 3000
+
+// nextPageURL returns the rel="next" URL from a Link header or an empty string if there is no next page
+func nextPageURL(linkHeader string) string {
+	for _, link := range strings.Split(linkHeader, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, p := range parts[1:] {
+			if strings.TrimSpace(p) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+func getPullRequestFiles(scm scmConnection, pull GithubPullRequest) ([]changedFile, error) {
+	var files []changedFile
+
+	url := fmt.Sprintf("%s/files?per_page=100", githubPullURL(scm, pull))
+	for url != "" && len(files) < githubMaxPullRequestFiles {
+		resp, err := ghreq(http.MethodGet, url, scm, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("did not get OK status: %s", resp.Status)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var ghfiles []githubPullRequestFile
+		if err := json.Unmarshal(body, &ghfiles); err != nil {
+			return nil, err
+		}
+
+		for _, f := range ghfiles {
+			if f.Patch == "" && f.Changes > 0 {
+				// Github leaves out the patch of diffs which are too large
+				if manifestParserFor(f.Filename) != nil {
+					log.Printf("WARN: Github did not include the diff of manifest %s (%d changes), probably because it is too large. It will not be reviewed\n", f.Filename, f.Changes)
+				} else {
+					log.Printf("TRACE: Github did not include the diff of %s (%d changes)\n", f.Filename, f.Changes)
+				}
+			}
+			files = append(files, changedFile{Filename: f.Filename, Patch: f.Patch})
+		}
+
+		url = nextPageURL(resp.Header.Get("Link"))
+	}
+
+	if url != "" || pull.PullRequest.ChangedFiles > githubMaxPullRequestFiles {
+		log.Printf("WARN: pull request changes %d files but Github only lists the first %d\n", pull.PullRequest.ChangedFiles, githubMaxPullRequestFiles)
+	}
+
+	return files, nil
+}
+
+// GitHub only lists up to this many files of a comparison, however many pages it is split into
+const githubMaxCompareFiles = 300
+
+// getCommitRangeFiles returns the files changed between two commits of the pull request's repository.
+// Returns an error if GitHub may have left files out, as the changes would then be incomplete.
+func getCommitRangeFiles(scm scmConnection, pull GithubPullRequest, base, head string) ([]changedFile, error) {
+	var files []changedFile
+
+	url := fmt.Sprintf("%s/compare/%s...%s?per_page=100", githubRepoURL(scm, pull.Repository.FullName), url.PathEscape(base), url.PathEscape(head))
+	for url != "" {
+		resp, err := ghreq(http.MethodGet, url, scm, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("did not get OK status: %s", resp.Status)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var compare githubCompare
+		if err := json.Unmarshal(body, &compare); err != nil {
+			return nil, err
+		}
+
+		for _, f := range compare.Files {
+			files = append(files, changedFile{Filename: f.Filename, Patch: f.Patch})
+		}
+
+		url = nextPageURL(resp.Header.Get("Link"))
+	}
+
+	if len(files) >= githubMaxCompareFiles {
+		return nil, fmt.Errorf("comparison changes at least %d files, which is more than Github lists", len(files))
 	}
 
-	return client.Do(request)
+	return files, nil
 }
 
-func getPullRequestFiles(token string, pull GithubPullRequest) ([]changedFile, error) {
-	resp, err := ghreq(http.MethodGet, fmt.Sprintf("%s/files", pull.PullRequest.URL), token, nil)
+// getPullRequestFileContent returns the content of a file as of the pull request's head commit
+func getPullRequestFileContent(scm scmConnection, pull GithubPullRequest, filename string) (string, error) {
+	// The head may be in a fork
+	fullName := pull.PullRequest.Head.Repo.FullName
+	if fullName == "" {
+		fullName = pull.Repository.FullName
+	}
+
+	segments := strings.Split(filename, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	u := fmt.Sprintf("%s/contents/%s?ref=%s", githubRepoURL(scm, fullName), strings.Join(segments, "/"), url.QueryEscape(pull.PullRequest.Head.SHA))
+
+	resp, err := ghreq(http.MethodGet, u, scm, nil)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("did not get OK status: %s", resp.Status)
+		return "", fmt.Errorf("did not get OK status: %s", resp.Status)
 	}
 
-	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	var ghfiles []githubPullRequestFile
-	if err := json.Unmarshal(body, &ghfiles); err != nil {
-		return nil, err
+	var content githubContent
+	if err := json.Unmarshal(body, &content); err != nil {
+		return "", err
+	}
+	if content.Encoding != "base64" {
+		return "", fmt.Errorf("unsupported content encoding %q", content.Encoding)
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(strings.Replace(content.Content, "\n", "", -1))
+	if err != nil {
+		return "", fmt.Errorf("could not decode content: %v", err)
 	}
+	return string(buf), nil
+}
 
-	files := make([]changedFile, len(ghfiles))
-	for i, f := range ghfiles {
-		files[i] = changedFile{Filename: f.Filename, Patch: f.Patch}
+// getPullRequestCommentedLines returns the lines of the pull request which already have a remediation comment
+func getPullRequestCommentedLines(scm scmConnection, pull GithubPullRequest) (commentedLines, error) {
+	commented := make(commentedLines)
+
+	url := fmt.Sprintf("%s/comments?per_page=100", githubPullURL(scm, pull))
+	for url != "" {
+		resp, err := ghreq(http.MethodGet, url, scm, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("did not get OK status: %s", resp.Status)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var comments []githubPullRequestComment
+		if err := json.Unmarshal(body, &comments); err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			commented.add(c.Path, c.Line, c.Body)
+		}
+
+		url = nextPageURL(resp.Header.Get("Link"))
 	}
 
-	return files, err
+	return commented, nil
 }
 
-func addPullRequestComment(token string, pull GithubPullRequest, position int64, path, comment string) error {
+func addPullRequestComment(scm scmConnection, pull GithubPullRequest, position int64, path, comment string) error {
 	request := githubPullRequestCommentSinglelineRequest{
 		CommitID: pull.PullRequest.Head.SHA,
 		Path:     path,
@@ -294,7 +526,7 @@ func addPullRequestComment(token string, pull GithubPullRequest, position int64,
 		return fmt.Errorf("could not create request: %s", err)
 	}
 
-	resp, err := ghreq(http.MethodPost, pull.PullRequest.ReviewCommentsURL, token, bytes.NewBuffer(buf))
+	resp, err := ghreq(http.MethodPost, githubPullURL(scm, pull)+"/comments", scm, bytes.NewBuffer(buf))
 	if err != nil {
 		log.Printf("ERROR: error creating comment: %s", err)
 		log.Printf("TRACE: %s", buf)
@@ -330,18 +562,37 @@ func IsValidGithubWebhookPullRequestEvent(reqHeaders map[string]string) (bool, i
 }
 
 // ProcessPullRequestForRemediations will take a Github pull request and add any remediations if a manifest is found
-func ProcessPullRequestForRemediations(iq nexusiq.IQ, iqApp, token string, pull GithubPullRequest) error {
+func ProcessPullRequestForRemediations(iq nexusiq.IQ, repo repoConfig, scm scmConnection, pull GithubPullRequest) error {
 	log.Printf("TRACE: Received Pull Request from: %s\n", pull.Repository.HTMLURL)
 
-	files, err := getPullRequestFiles(token, pull)
+	files, err := getPullRequestFiles(scm, pull)
 	if err != nil {
 		log.Printf("ERROR: could not get files from pull request: %v\n", err)
 		return fmt.Errorf("could not get files from pull request: %v", err)
 	}
 	log.Printf("TRACE: Got %d files from pull request\n", len(files))
 
-	if err = addRemediationsToRequest(iq, iqApp, files, func(filename string, location changeLocation, comment string) error {
-		return addPullRequestComment(token, pull, location.Position, filename, comment)
+	// On a push to the pull request, only review what changed since the previously reviewed head
+	var changes []changedFile
+	if pull.Action == "synchronize" && pull.Before != "" {
+		changes, err = getCommitRangeFiles(scm, pull, pull.Before, pull.PullRequest.Head.SHA)
+		if err != nil {
+			log.Printf("WARN: could not get changes since %s, reviewing the whole pull request: %v\n", pull.Before, err)
+			changes = nil
+		}
+	}
+
+	// Reopening the pull request or marking it ready reviews it again, which mustn't repeat earlier comments
+	commented, err := getPullRequestCommentedLines(scm, pull)
+	if err != nil {
+		log.Printf("WARN: could not get existing comments, lines may be commented on again: %v\n", err)
+	}
+
+	content := func(filename string) (string, error) {
+		return getPullRequestFileContent(scm, pull, filename)
+	}
+	if err = addRemediationsToRequest(iq, repo, files, changes, commented, githubSuggestionFence, content, func(filename string, location changeLocation, comment string) error {
+		return addPullRequestComment(scm, pull, location.Position, filename, comment)
 	}); err != nil {
 		return fmt.Errorf("could not add remediation comments to request: %v", err)
 	}
@@ -349,18 +600,20 @@ func ProcessPullRequestForRemediations(iq nexusiq.IQ, iqApp, token string, pull
 	return nil
 }
 
-// HandleGithubWebhookPullRequestEvent unmarshals a pull request event from Github and remediates if it is a new one
-func HandleGithubWebhookPullRequestEvent(iq nexusiq.IQ, iqApp, token string, payload []byte) (int, error) {
+// HandleGithubWebhookPullRequestEvent unmarshals a pull request event from Github and remediates if it is new or has been updated
+func HandleGithubWebhookPullRequestEvent(iq nexusiq.IQ, repo repoConfig, scm scmConnection, payload []byte) (int, error) {
 	var event GithubPullRequest
 	if err := json.Unmarshal(payload, &event); err != nil {
 		return http.StatusBadRequest, fmt.Errorf("could not unmarshal payload as json: %v", err)
 	}
 
-	if event.Action != "opened" {
-		return http.StatusNoContent, fmt.Errorf("Only processing new pull requests")
+	switch event.Action {
+	case "opened", "synchronize", "reopened", "ready_for_review":
+	default:
+		return http.StatusNoContent, fmt.Errorf("Only processing new or updated pull requests")
 	}
 
-	if err := ProcessPullRequestForRemediations(iq, iqApp, token, event); err != nil {
+	if err := ProcessPullRequestForRemediations(iq, repo, scm, event); err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("error: error handling pull request: %v", err)
 	}
 
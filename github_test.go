@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func Test_verifyGithubWebhookSignature(t *testing.T) {
+	// Example from https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+	const (
+		secret    = "It's a Secret to Everybody"
+		payload   = "Hello, World!"
+		signature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		secret  string
+		wantErr bool
+	}{
+		{
+			"valid",
+			map[string]string{"X-Hub-Signature-256": signature},
+			secret,
+			false,
+		},
+		{
+			"header case",
+			map[string]string{"x-hub-signature-256": signature},
+			secret,
+			false,
+		},
+		{
+			"wrong secret",
+			map[string]string{"X-Hub-Signature-256": signature},
+			"not the secret",
+			true,
+		},
+		{
+			"missing",
+			map[string]string{},
+			secret,
+			true,
+		},
+		{
+			"sha1 only",
+			map[string]string{"X-Hub-Signature-256": "sha1=01dc10d0c83e72ed246219cdd91669667fe2ca59"},
+			secret,
+			true,
+		},
+		{
+			"not hex",
+			map[string]string{"X-Hub-Signature-256": "sha256=zzzz"},
+			secret,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyGithubWebhookSignature(tt.headers, []byte(payload), tt.secret); (err != nil) != tt.wantErr {
+				t.Errorf("verifyGithubWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_nextPageURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"empty", "", ""},
+		{
+			"next and last",
+			`<https://api.github.com/repositories/1/pulls/2/files?per_page=100&page=2>; rel="next", <https://api.github.com/repositories/1/pulls/2/files?per_page=100&page=3>; rel="last"`,
+			"https://api.github.com/repositories/1/pulls/2/files?per_page=100&page=2",
+		},
+		{
+			"last page",
+			`<https://api.github.com/repositories/1/pulls/2/files?per_page=100&page=1>; rel="prev", <https://api.github.com/repositories/1/pulls/2/files?per_page=100&page=1>; rel="first"`,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPageURL(tt.header); got != tt.want {
+				t.Errorf("nextPageURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getPullRequestFiles(t *testing.T) {
+	const pages, perPage = 3, 100
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("per_page"); got != strconv.Itoa(perPage) {
+			t.Errorf("requested per_page = %q, want %d", got, perPage)
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		if page < pages {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/owner/repo/pulls/1/files?per_page=%d&page=%d>; rel="next"`, srv.URL, perPage, page+1))
+		}
+
+		files := make([]githubPullRequestFile, perPage)
+		for i := range files {
+			files[i] = githubPullRequestFile{Filename: fmt.Sprintf("%d/%d/package.json", page, i), Patch: "@@ -1,1 +1,1 @@", Changes: 2}
+		}
+		json.NewEncoder(w).Encode(files)
+	}))
+	defer srv.Close()
+
+	var pull GithubPullRequest
+	pull.Repository.FullName = "owner/repo"
+	pull.PullRequest.Number = 1
+
+	files, err := getPullRequestFiles(scmConnection{apiURL: srv.URL, token: "token", client: srv.Client()}, pull)
+	if err != nil {
+		t.Fatalf("getPullRequestFiles() error = %v", err)
+	}
+	if len(files) != pages*perPage {
+		t.Errorf("getPullRequestFiles() returned %d files, want %d", len(files), pages*perPage)
+	}
+	if last := files[len(files)-1].Filename; last != "3/99/package.json" {
+		t.Errorf("getPullRequestFiles() last file = %s, want 3/99/package.json", last)
+	}
+}
+
+func Test_getCommitRangeFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []int
+		wantErr bool
+	}{
+		{"one page", []int{2}, false},
+		// Only the first page of a comparison lists files, the others list more of its commits
+		{"commits over several pages", []int{2, 0, 0}, false},
+		{"more files than Github lists", []int{githubMaxCompareFiles}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/owner/repo/compare/abc...def" {
+					t.Errorf("requested path %s", r.URL.Path)
+				}
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				if page == 0 {
+					page = 1
+				}
+				if page < len(tt.files) {
+					w.Header().Set("Link", fmt.Sprintf(`<%s/repos/owner/repo/compare/abc...def?per_page=100&page=%d>; rel="next"`, srv.URL, page+1))
+				}
+
+				var compare githubCompare
+				for i := 0; i < tt.files[page-1]; i++ {
+					compare.Files = append(compare.Files, githubPullRequestFile{Filename: fmt.Sprintf("%d/package.json", i), Patch: "@@ -1,1 +1,1 @@"})
+				}
+				json.NewEncoder(w).Encode(compare)
+			}))
+			defer srv.Close()
+
+			var pull GithubPullRequest
+			pull.Repository.FullName = "owner/repo"
+
+			files, err := getCommitRangeFiles(scmConnection{apiURL: srv.URL, token: "token", client: srv.Client()}, pull, "abc", "def")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getCommitRangeFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(files) != tt.files[0] {
+				t.Errorf("getCommitRangeFiles() returned %d files, want %d", len(files), tt.files[0])
+			}
+		})
+	}
+}
+
+func Test_getPullRequestCommentedLines(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/pulls/1/comments" {
+			t.Errorf("requested path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/owner/repo/pulls/1/comments?per_page=100&page=2>; rel="next"`, srv.URL))
+			fmt.Fprintf(w, `[{"path":"package.json","line":78,"body":%q},{"path":"package.json","line":80,"body":"LGTM"}]`, commentIntro+" that this version")
+			return
+		}
+		// Comments on outdated lines have no line
+		fmt.Fprintf(w, `[{"path":"pom.xml","line":12,"body":%q},{"path":"pom.xml","line":null,"body":%q}]`, commentIntro+" that this version", commentIntro+" that this version")
+	}))
+	defer srv.Close()
+
+	var pull GithubPullRequest
+	pull.Repository.FullName = "owner/repo"
+	pull.PullRequest.Number = 1
+
+	got, err := getPullRequestCommentedLines(scmConnection{apiURL: srv.URL, token: "token", client: srv.Client()}, pull)
+	if err != nil {
+		t.Fatalf("getPullRequestCommentedLines() error = %v", err)
+	}
+	want := commentedLines{"package.json": {78: true}, "pom.xml": {12: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getPullRequestCommentedLines() = %v, want %v", got, want)
+	}
+}
+
+func Test_getPullRequestFileContent(t *testing.T) {
+	const content = "{\n  \"dependencies\": {}\n}\n"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/fork/repo/contents/web app/package.json" {
+			t.Errorf("requested path %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("ref"); got != "abc123" {
+			t.Errorf("requested ref = %q, want abc123", got)
+		}
+		// Github wraps the base64 content every 60 characters
+		encoded := base64.StdEncoding.EncodeToString([]byte(content))
+		json.NewEncoder(w).Encode(githubContent{Content: encoded[:10] + "\n" + encoded[10:], Encoding: "base64"})
+	}))
+	defer srv.Close()
+
+	var pull GithubPullRequest
+	pull.Repository.FullName = "owner/repo"
+	pull.PullRequest.Head.Repo.FullName = "fork/repo"
+	pull.PullRequest.Head.SHA = "abc123"
+
+	got, err := getPullRequestFileContent(scmConnection{apiURL: srv.URL, token: "token", client: srv.Client()}, pull, "web app/package.json")
+	if err != nil {
+		t.Fatalf("getPullRequestFileContent() error = %v", err)
+	}
+	if got != content {
+		t.Errorf("getPullRequestFileContent() = %q, want %q", got, content)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// githubApp holds the credentials to authenticate as a Github App instead of with a personal access token
+type githubApp struct {
+	AppID          int64  `json:"app_id,omitempty"`
+	PrivateKey     string `json:"private_key,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+}
+
+// merge returns a copy of the app with any fields set in other overriding its own
+func (a githubApp) merge(other githubApp) githubApp {
+	if other.AppID != 0 {
+		a.AppID = other.AppID
+	}
+	if other.PrivateKey != "" {
+		a.PrivateKey = other.PrivateKey
+	}
+	if other.PrivateKeyFile != "" {
+		a.PrivateKeyFile = other.PrivateKeyFile
+	}
+	return a
+}
+
+func (a githubApp) configured() bool {
+	return a.AppID != 0 && (a.PrivateKey != "" || a.PrivateKeyFile != "")
+}
+
+func (a githubApp) privateKey() (*rsa.PrivateKey, error) {
+	keyPEM := []byte(a.PrivateKey)
+	if a.PrivateKeyFile != "" {
+		buf, err := ioutil.ReadFile(a.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read private key file: %v", err)
+		}
+		keyPEM = buf
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("could not decode private key PEM")
+	}
+
+	// Github generates PKCS#1 keys, but accept PKCS#8 in case it was converted
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// jwt creates the RS256 signed token which authenticates as the app itself
+func (a githubApp) jwt(now time.Time) (string, error) {
+	key, err := a.privateKey()
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	// Backdate to allow for clock drift. Github allows at most 10 minutes until expiry.
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign token: %v", err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+// POST /app/installations/:installation_id/access_tokens
+type githubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	installationTokensMu sync.Mutex
+	installationTokens   = make(map[string]githubInstallationToken)
+)
+
+// installationToken returns a token to act as the app's installation, reusing a cached one until shortly before it expires.
+// The app's JWT can create tokens for every installation, so apiURL must come from the configuration.
+// The cache is not locked while fetching so one slow request does not hold up every other event, at the cost of concurrent misses each fetching a token.
+func (a githubApp) installationToken(client *http.Client, apiURL string, installationID int64) (string, error) {
+	cacheKey := fmt.Sprintf("%s|%d|%d", apiURL, a.AppID, installationID)
+
+	installationTokensMu.Lock()
+	cached, ok := installationTokens[cacheKey]
+	installationTokensMu.Unlock()
+	if ok && time.Now().Add(5*time.Minute).Before(cached.ExpiresAt) {
+		return cached.Token, nil
+	}
+
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return "", fmt.Errorf("could not create Github App token: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", apiURL, installationID)
+	log.Printf("TRACE: req(%s, %s, payload)", http.MethodPost, url)
+	request, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create HTTP request: %v", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	request.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("could not request installation token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("could not request installation token. got status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var token githubInstallationToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("could not unmarshal installation token: %v", err)
+	}
+
+	installationTokensMu.Lock()
+	installationTokens[cacheKey] = token
+	installationTokensMu.Unlock()
+	return token.Token, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGithubApp(t *testing.T) (githubApp, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return githubApp{AppID: 42, PrivateKey: string(keyPEM)}, key
+}
+
+func Test_githubApp_jwt(t *testing.T) {
+	app, key := newTestGithubApp(t)
+	now := time.Unix(1600000000, 0)
+
+	token, err := app.jwt(now)
+	if err != nil {
+		t.Fatalf("jwt() error = %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("jwt() = %s, want three parts", token)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("jwt() signature does not verify: %v", err)
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims struct {
+		IAT int64  `json:"iat"`
+		EXP int64  `json:"exp"`
+		ISS string `json:"iss"`
+	}
+	if err := json.Unmarshal(buf, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.ISS != "42" || claims.IAT >= now.Unix() || claims.EXP-claims.IAT > 600 {
+		t.Errorf("jwt() claims = %+v", claims)
+	}
+
+	if _, err := (githubApp{AppID: 42, PrivateKey: "not a key"}).jwt(now); err == nil {
+		t.Error("jwt() expected error for invalid private key")
+	}
+}
+
+func Test_githubApp_installationToken(t *testing.T) {
+	app, _ := newTestGithubApp(t)
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodPost || r.URL.Path != "/app/installations/7/access_tokens" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			t.Errorf("did not authenticate with the app JWT")
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token":"ghs_%d","expires_at":%q}`, requests, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		token, err := app.installationToken(srv.Client(), srv.URL, 7)
+		if err != nil {
+			t.Fatalf("installationToken() error = %v", err)
+		}
+		if token != "ghs_1" {
+			t.Errorf("installationToken() = %s, want ghs_1", token)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("requested %d tokens, want the cached one to be reused", requests)
+	}
+}
+
+func Test_newSCMConnection_githubApp(t *testing.T) {
+	app, _ := newTestGithubApp(t)
+	gheApp, _ := newTestGithubApp(t)
+	gheApp.AppID = 43
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/api/v3/app/installations/9/access_tokens" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		// Only the host's own app may sign tokens sent to it, never the top-level app for github.com
+		jwt := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		claims, _ := base64.RawURLEncoding.DecodeString(jwt[len(jwt)-2])
+		if !strings.Contains(string(claims), `"iss":"43"`) {
+			t.Errorf("sent a JWT with claims %s to a Github Enterprise host, want the host's app", claims)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token":"ghs_ghe","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		host         scmHost
+		wantToken    string
+		wantRequests int
+	}{
+		{"host app", scmHost{APIURL: srv.URL + "/api/v3", GithubApp: gheApp}, "ghs_ghe", 1},
+		{"only the top-level app", scmHost{APIURL: srv.URL + "/api/v3"}, "pat", 0},
+		{"host app without a key", scmHost{APIURL: srv.URL + "/api/v3", GithubApp: githubApp{AppID: 43}}, "pat", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = 0
+			installationTokensMu.Lock()
+			installationTokens = make(map[string]githubInstallationToken)
+			installationTokensMu.Unlock()
+
+			cfg := config{GithubApp: app, Hosts: map[string]scmHost{"ghe.example.com": tt.host}}
+			scm, err := newSCMConnection(cfg, webhookRepository{host: "ghe.example.com", kind: "github", installationID: 9}, "pat")
+			if err != nil {
+				t.Fatalf("newSCMConnection() error = %v", err)
+			}
+			if scm.token != tt.wantToken || requests != tt.wantRequests {
+				t.Errorf("newSCMConnection() token = %q after %d requests, want %q after %d", scm.token, requests, tt.wantToken, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,7 +10,8 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
 
 	nexusiq "github.com/sonatype-nexus-community/gonexus/iq"
 )
@@ -128,6 +130,19 @@ type change struct {
 	Diff        string `json:"diff"`
 }
 
+// GET /projects/:id/merge_requests/:merge_request_iid/discussions
+type gitlabDiscussion struct {
+	Notes []struct {
+		Body     string   `json:"body"`
+		Position position `json:"position"`
+	} `json:"notes"`
+}
+
+// GET /projects/:id/repository/compare
+type gitlabCompare struct {
+	Diffs []change `json:"diffs"`
+}
+
 type gitlabMergeRequestWebhookEvent struct {
 	ObjectKind       string           `json:"object_kind"`
 	User             eventUser        `json:"user"`
@@ -194,6 +209,7 @@ type objectAttributes struct {
 	WorkInProgress  bool      `json:"work_in_progress"`
 	URL             string    `json:"url"`
 	Action          string    `json:"action"`
+	OldRev          string    `json:"oldrev,omitempty"`
 	Assignee        eventUser `json:"assignee"`
 }
 
@@ -236,31 +252,26 @@ type repository struct {
 	Homepage    string `json:"homepage"`
 }
 
-func glreq(method, endpoint, token string, payload io.Reader) (*http.Response, error) {
-	url := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s", endpoint)
-	log.Printf("TRACE: req(%s, %s, %s, payload)", method, url, token)
+func glreq(method, endpoint string, scm scmConnection, payload io.Reader) (*http.Response, error) {
+	url := fmt.Sprintf("%s/projects/%s", scm.apiURL, endpoint)
+	log.Printf("TRACE: req(%s, %s, payload)", method, url)
 	request, err := http.NewRequest(method, url, payload)
 	if err != nil {
 		return nil, fmt.Errorf("could not create HTTP request: %v", err)
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", token)
+	request.Header.Set("PRIVATE-TOKEN", scm.token)
 
 	if payload != nil {
 		request.Header.Set("Content-Type", "application/json")
 	}
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-	}
-
-	return client.Do(request)
-
+	return scm.client.Do(request)
 }
 
-func getMergeRequestFiles(token string, mr GitlabMergeRequest) ([]changedFile, error) {
+func getMergeRequestFiles(scm scmConnection, mr GitlabMergeRequest) ([]changedFile, error) {
 	endpoint := fmt.Sprintf("%d/merge_requests/%d/changes", mr.ProjectID, mr.Iid)
-	resp, err := glreq(http.MethodGet, endpoint, token, nil)
+	resp, err := glreq(http.MethodGet, endpoint, scm, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -288,9 +299,66 @@ func getMergeRequestFiles(token string, mr GitlabMergeRequest) ([]changedFile, e
 	return files, err
 }
 
-func getMergeRequest(token string, projectID, mrIID int64) (GitlabMergeRequest, error) {
+// getCommitRangeChanges returns the files changed between two commits of the project
+func getCommitRangeChanges(scm scmConnection, projectID int64, from, to string) ([]changedFile, error) {
+	endpoint := fmt.Sprintf("%d/repository/compare?from=%s&to=%s", projectID, url.QueryEscape(from), url.QueryEscape(to))
+	resp, err := glreq(http.MethodGet, endpoint, scm, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("did not get OK status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var compare gitlabCompare
+	if err := json.Unmarshal(body, &compare); err != nil {
+		return nil, err
+	}
+
+	files := make([]changedFile, len(compare.Diffs))
+	for i, f := range compare.Diffs {
+		files[i] = changedFile{Filename: f.NewPath, Patch: f.Diff}
+	}
+
+	return files, nil
+}
+
+// getMergeRequestFileContent returns the content of a file as of the merge request's head commit
+func getMergeRequestFileContent(scm scmConnection, mr GitlabMergeRequest, filename string) (string, error) {
+	// The source branch may be in a fork
+	projectID := mr.SourceProjectID
+	if projectID == 0 {
+		projectID = mr.ProjectID
+	}
+
+	endpoint := fmt.Sprintf("%d/repository/files/%s/raw?ref=%s", projectID, url.PathEscape(filename), url.QueryEscape(mr.SHA))
+	resp, err := glreq(http.MethodGet, endpoint, scm, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("did not get OK status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func getMergeRequest(scm scmConnection, projectID, mrIID int64) (GitlabMergeRequest, error) {
 	endpoint := fmt.Sprintf("%d/merge_requests/%d", projectID, mrIID)
-	resp, err := glreq("GET", endpoint, token, nil)
+	resp, err := glreq("GET", endpoint, scm, nil)
 	if err != nil {
 		return GitlabMergeRequest{}, err
 	}
@@ -311,7 +379,46 @@ func getMergeRequest(token string, projectID, mrIID int64) (GitlabMergeRequest,
 	return mr, nil
 }
 
-func addMergeRequestComment(token string, mr GitlabMergeRequest, line int64, path, comment string) error {
+// getMergeRequestCommentedLines returns the lines of the merge request which already have a remediation comment
+func getMergeRequestCommentedLines(scm scmConnection, mr GitlabMergeRequest) (commentedLines, error) {
+	commented := make(commentedLines)
+
+	for page := "1"; page != ""; {
+		endpoint := fmt.Sprintf("%d/merge_requests/%d/discussions?per_page=100&page=%s", mr.ProjectID, mr.Iid, page)
+		resp, err := glreq(http.MethodGet, endpoint, scm, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("did not get OK status: %s", resp.Status)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var discussions []gitlabDiscussion
+		if err := json.Unmarshal(body, &discussions); err != nil {
+			return nil, err
+		}
+		for _, d := range discussions {
+			// The first note starts the discussion, the others are replies to it
+			if len(d.Notes) > 0 {
+				commented.add(d.Notes[0].Position.NewPath, d.Notes[0].Position.NewLine, d.Notes[0].Body)
+			}
+		}
+
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return commented, nil
+}
+
+func addMergeRequestComment(scm scmConnection, mr GitlabMergeRequest, line int64, path, comment string) error {
 	discussionReq := gitlabDiscussionRequest{
 		ID:              mr.ProjectID,
 		MergeRequestIID: mr.Iid,
@@ -335,7 +442,7 @@ func addMergeRequestComment(token string, mr GitlabMergeRequest, line int64, pat
 	fmt.Println(string(buf))
 
 	endpoint := fmt.Sprintf("%d/merge_requests/%d/discussions", mr.ProjectID, mr.Iid)
-	resp, err := glreq(http.MethodPost, endpoint, token, bytes.NewBuffer(buf))
+	resp, err := glreq(http.MethodPost, endpoint, scm, bytes.NewBuffer(buf))
 	if err != nil {
 		log.Printf("ERROR: error creating comment: %s", err)
 		log.Printf("TRACE: %s", buf)
@@ -353,13 +460,76 @@ func addMergeRequestComment(token string, mr GitlabMergeRequest, line int64, pat
 }
 
 func getGitlabEventType(requestHeaders map[string]string) (string, error) {
-	eventType, ok := requestHeaders["X-Gitlab-Event"]
+	eventType, ok := getHeader(requestHeaders, "X-Gitlab-Event")
 	if !ok {
 		return "", errors.New("error: did not receive a gitlab event")
 	}
 	return eventType, nil
 }
 
+// gitlabWebhookSecrets maps a project's path with namespace to the secret tokens its webhooks can use.
+// More than one token allows for rotation. The "*" entry applies to projects without their own entry.
+type gitlabWebhookSecrets map[string][]string
+
+// parseGitlabWebhookSecrets reads secrets in the form "group/project=token1,token2;*=token3"
+func parseGitlabWebhookSecrets(config string) gitlabWebhookSecrets {
+	secrets := make(gitlabWebhookSecrets)
+	for _, entry := range strings.Split(config, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		project, tokens := "*", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			project, tokens = strings.TrimSpace(entry[:i]), entry[i+1:]
+		}
+
+		for _, t := range strings.Split(tokens, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				secrets[project] = append(secrets[project], t)
+			}
+		}
+	}
+	return secrets
+}
+
+func (s gitlabWebhookSecrets) forProject(path string) []string {
+	if tokens, ok := s[path]; ok {
+		return tokens
+	}
+	return s["*"]
+}
+
+// verifyGitlabWebhookToken checks the X-Gitlab-Token header matches one of the secrets configured for the event's project
+func verifyGitlabWebhookToken(requestHeaders map[string]string, payload []byte, secrets gitlabWebhookSecrets) error {
+	token, ok := getHeader(requestHeaders, "X-Gitlab-Token")
+	if !ok || token == "" {
+		return errors.New("did not receive a webhook token")
+	}
+
+	var event gitlabMergeRequestWebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("could not unmarshal payload as json: %v", err)
+	}
+
+	expected := secrets.forProject(event.Project.PathWithNamespace)
+	if len(expected) == 0 {
+		return fmt.Errorf("no webhook secret configured for project %q", event.Project.PathWithNamespace)
+	}
+
+	// Compare against every secret so the time taken does not reveal which one matched
+	var match int
+	for _, e := range expected {
+		match |= subtle.ConstantTimeCompare([]byte(token), []byte(e))
+	}
+	if match != 1 {
+		return fmt.Errorf("webhook token does not match for project %q", event.Project.PathWithNamespace)
+	}
+
+	return nil
+}
+
 // IsValidGitlabWebhookMergeRequestEvent returns true if the given HTTP headers are for a valid merge request.
 // Also returns a valid http status code.
 func IsValidGitlabWebhookMergeRequestEvent(reqHeaders map[string]string) (bool, int) {
@@ -378,42 +548,67 @@ func IsValidGitlabWebhookMergeRequestEvent(reqHeaders map[string]string) (bool,
 	return true, http.StatusOK
 }
 
-// ProcessMergeRequestForRemediations will take a Gitlab merge request and add any remediations if a manifest is found
-func ProcessMergeRequestForRemediations(iq nexusiq.IQ, iqApp, token string, mr GitlabMergeRequest) error {
+// ProcessMergeRequestForRemediations will take a Gitlab merge request and add any remediations if a manifest is found.
+// If since is a commit SHA, only changes made after it are reviewed.
+func ProcessMergeRequestForRemediations(iq nexusiq.IQ, repo repoConfig, scm scmConnection, mr GitlabMergeRequest, since string) error {
 	log.Printf("TRACE: Received Merge Request from: %s\n", mr.WebURL)
 
-	files, err := getMergeRequestFiles(token, mr)
+	files, err := getMergeRequestFiles(scm, mr)
 	if err != nil {
 		log.Printf("ERROR: could not get files from merge request: %v\n", err)
 		return fmt.Errorf("could not get files from merge request: %v", err)
 	}
 	log.Printf("TRACE: Got %d files from merge request\n", len(files))
 
-	if err = addRemediationsToRequest(iq, iqApp, files, func(filename string, location changeLocation, comment string) error {
-		return addMergeRequestComment(token, mr, location.Line, filename, comment)
+	var changes []changedFile
+	if since != "" {
+		changes, err = getCommitRangeChanges(scm, mr.ProjectID, since, mr.SHA)
+		if err != nil {
+			log.Printf("WARN: could not get changes since %s, reviewing the whole merge request: %v\n", since, err)
+			changes = nil
+		}
+	}
+
+	// Reopening the merge request reviews it again, which mustn't repeat earlier comments
+	commented, err := getMergeRequestCommentedLines(scm, mr)
+	if err != nil {
+		log.Printf("WARN: could not get existing comments, lines may be commented on again: %v\n", err)
+	}
+
+	content := func(filename string) (string, error) {
+		return getMergeRequestFileContent(scm, mr, filename)
+	}
+	if err = addRemediationsToRequest(iq, repo, files, changes, commented, gitlabSuggestionFence, content, func(filename string, location changeLocation, comment string) error {
+		return addMergeRequestComment(scm, mr, location.Line, filename, comment)
 	}); err != nil {
 		return fmt.Errorf("could not add remediation comments to request: %v", err)
 	}
 	return nil
 }
 
-// HandleGitlabWebhookMergeRequestEvent unmarshals a merge request event from Gitlab and remediates if it is a new one
-func HandleGitlabWebhookMergeRequestEvent(iq nexusiq.IQ, iqApp, token string, payload []byte) (int, error) {
+// HandleGitlabWebhookMergeRequestEvent unmarshals a merge request event from Gitlab and remediates if it is new or has new commits
+func HandleGitlabWebhookMergeRequestEvent(iq nexusiq.IQ, repo repoConfig, scm scmConnection, payload []byte) (int, error) {
 	var event gitlabMergeRequestWebhookEvent
 	if err := json.Unmarshal(payload, &event); err != nil {
 		return http.StatusBadRequest, fmt.Errorf("could not unmarshal payload as json: %v", err)
 	}
 
-	if event.ObjectAttributes.State != "opened" {
-		return http.StatusNoContent, fmt.Errorf("Only processing new merge requests")
+	switch {
+	case event.ObjectAttributes.State != "opened":
+		return http.StatusNoContent, fmt.Errorf("Only processing open merge requests")
+	case event.ObjectAttributes.Action == "update" && event.ObjectAttributes.OldRev == "":
+		// Updates without an oldrev are edits to the title, labels, etc. rather than new commits
+		return http.StatusNoContent, fmt.Errorf("Only processing merge request updates with new commits")
+	case event.ObjectAttributes.Action != "open" && event.ObjectAttributes.Action != "reopen" && event.ObjectAttributes.Action != "update":
+		return http.StatusNoContent, fmt.Errorf("Only processing new or updated merge requests")
 	}
 
-	mr, err := getMergeRequest(token, event.Project.ID, event.ObjectAttributes.Iid)
+	mr, err := getMergeRequest(scm, event.Project.ID, event.ObjectAttributes.Iid)
 	if err != nil {
 		return http.StatusBadRequest, fmt.Errorf("could not find merge request: %v", err)
 	}
 
-	if err := ProcessMergeRequestForRemediations(iq, iqApp, token, mr); err != nil {
+	if err := ProcessMergeRequestForRemediations(iq, repo, scm, mr, event.ObjectAttributes.OldRev); err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("error: error handling merge request: %v", err)
 	}
 
@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
 
 func Test_addMergeRequestComment(t *testing.T) {
 	/*
@@ -37,7 +43,7 @@ func Test_addMergeRequestComment(t *testing.T) {
 		addMergeRequestComment(token, mr, pos, file, comment)
 	*/
 	type args struct {
-		token   string
+		scm     scmConnection
 		mr      GitlabMergeRequest
 		pos     int64
 		path    string
@@ -51,7 +57,89 @@ func Test_addMergeRequestComment(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			addMergeRequestComment(tt.args.token, tt.args.mr, tt.args.pos, tt.args.path, tt.args.comment)
+			addMergeRequestComment(tt.args.scm, tt.args.mr, tt.args.pos, tt.args.path, tt.args.comment)
 		})
 	}
 }
+
+func Test_parseGitlabWebhookSecrets(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   gitlabWebhookSecrets
+	}{
+		{"empty", "", gitlabWebhookSecrets{}},
+		{"global", "tok", gitlabWebhookSecrets{"*": {"tok"}}},
+		{
+			"per project with rotation",
+			"group/app=new,old; *=fallback ;group/lib=lib",
+			gitlabWebhookSecrets{
+				"group/app": {"new", "old"},
+				"group/lib": {"lib"},
+				"*":         {"fallback"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseGitlabWebhookSecrets(tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGitlabWebhookSecrets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyGitlabWebhookToken(t *testing.T) {
+	payload := []byte(`{"object_kind":"merge_request","project":{"id":1,"path_with_namespace":"group/app"}}`)
+	secrets := gitlabWebhookSecrets{
+		"group/app": {"new", "old"},
+		"*":         {"fallback"},
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		secrets gitlabWebhookSecrets
+		wantErr bool
+	}{
+		{"current secret", map[string]string{"X-Gitlab-Token": "new"}, secrets, false},
+		{"rotated secret", map[string]string{"X-Gitlab-Token": "old"}, secrets, false},
+		{"header case", map[string]string{"x-gitlab-token": "new"}, secrets, false},
+		{"other project's secret", map[string]string{"X-Gitlab-Token": "fallback"}, secrets, true},
+		{"fallback", map[string]string{"X-Gitlab-Token": "fallback"}, gitlabWebhookSecrets{"*": {"fallback"}}, false},
+		{"no secret for project", map[string]string{"X-Gitlab-Token": "new"}, gitlabWebhookSecrets{"group/lib": {"new"}}, true},
+		{"missing", map[string]string{}, secrets, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyGitlabWebhookToken(tt.headers, payload, tt.secrets); (err != nil) != tt.wantErr {
+				t.Errorf("verifyGitlabWebhookToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_getMergeRequestCommentedLines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/1/merge_requests/2/discussions" {
+			t.Errorf("requested path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprintf(w, `[{"notes":[{"body":%q,"position":{"new_path":"Gemfile","new_line":47}},{"body":"thanks"}]},{"notes":[{"body":"general comment"}]}]`, commentIntro+" that this version")
+			return
+		}
+		fmt.Fprintf(w, `[{"notes":[{"body":"Is this needed?","position":{"new_path":"Gemfile","new_line":48}}]}]`)
+	}))
+	defer srv.Close()
+
+	scm := scmConnection{apiURL: srv.URL + "/api/v4", token: "token", client: srv.Client()}
+	got, err := getMergeRequestCommentedLines(scm, GitlabMergeRequest{ProjectID: 1, Iid: 2})
+	if err != nil {
+		t.Fatalf("getMergeRequestCommentedLines() error = %v", err)
+	}
+	want := commentedLines{"Gemfile": {47: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getMergeRequestCommentedLines() = %v, want %v", got, want)
+	}
+}
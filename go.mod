@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/aws/aws-lambda-go v1.13.2
+	github.com/aws/aws-sdk-go v1.25.37
 	github.com/package-url/packageurl-go v0.1.0
 	github.com/sonatype-nexus-community/gonexus v0.53.0
 )
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// goModule is a module path at a version, which is empty for the local directories a module can be replaced with
+type goModule struct {
+	path, version string
+}
+
+// goRequirement is a require directive, marked indirect when no package of the module is imported by the main module
+type goRequirement struct {
+	goModule
+	line     int64
+	indirect bool
+}
+
+// goReplacement is a replace directive, which applies to every version of a module if it doesn't give the version to replace
+type goReplacement struct {
+	from, to goModule
+	line     int64
+}
+
+// goModFile is what is needed of a go.mod to know which module versions are built
+type goModFile struct {
+	requires []goRequirement
+	replaces []goReplacement
+	excludes map[goModule]bool
+}
+
+var reGoVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+incompatible)?$`)
+
+// parseGoMod reads the require, replace and exclude directives of a go.mod, in single line and block form.
+// Lines outside of a block are read as requirements if they look like one, as the start of their block may not be in a patch.
+func parseGoMod(lines []patchLine) goModFile {
+	mod := goModFile{excludes: make(map[goModule]bool)}
+
+	var block string
+	for _, l := range lines {
+		text, comment := l.text, ""
+		if i := strings.Index(text, "//"); i >= 0 {
+			text, comment = text[:i], text[i+2:]
+		}
+		fields := strings.Fields(strings.Replace(text, `"`, "", -1))
+		if len(fields) == 0 {
+			continue
+		}
+
+		verb := block
+		switch {
+		case fields[0] == ")":
+			block = ""
+			continue
+		case fields[0] == "require" || fields[0] == "replace" || fields[0] == "exclude":
+			verb, fields = fields[0], fields[1:]
+			if len(fields) == 1 && fields[0] == "(" {
+				block = verb
+				continue
+			}
+		case fields[0] == "module" || fields[0] == "go" || fields[0] == "toolchain" || fields[0] == "retract":
+			if len(fields) > 1 && fields[len(fields)-1] == "(" {
+				block = fields[0]
+			}
+			continue
+		case verb == "" && strings.Contains(text, "=>"):
+			verb = "replace"
+		case verb == "":
+			verb = "require"
+		}
+
+		switch verb {
+		case "require":
+			if len(fields) == 2 && reGoVersion.MatchString(fields[1]) {
+				indirect := strings.TrimSpace(comment) == "indirect" || strings.HasPrefix(strings.TrimSpace(comment), "indirect;")
+				mod.requires = append(mod.requires, goRequirement{goModule{fields[0], fields[1]}, l.location.Line, indirect})
+			}
+		case "exclude":
+			if len(fields) == 2 {
+				mod.excludes[goModule{fields[0], fields[1]}] = true
+			}
+		case "replace":
+			r := goReplacement{line: l.location.Line}
+			switch {
+			case len(fields) >= 3 && fields[1] == "=>":
+				r.from, fields = goModule{fields[0], ""}, fields[2:]
+			case len(fields) >= 4 && fields[2] == "=>":
+				r.from, fields = goModule{fields[0], fields[1]}, fields[3:]
+			default:
+				continue
+			}
+			r.to = goModule{fields[0], ""}
+			if len(fields) == 2 {
+				r.to.version = fields[1]
+			}
+			mod.replaces = append(mod.replaces, r)
+		}
+	}
+	return mod
+}
+
+// replacement returns the replace directive which applies to a module, preferring one for its version over one for any version
+func (m goModFile) replacement(module goModule) (goReplacement, bool) {
+	var (
+		found goReplacement
+		ok    bool
+	)
+	for _, r := range m.replaces {
+		if r.from.path != module.path {
+			continue
+		}
+		if r.from.version == module.version {
+			return r, true
+		}
+		if r.from.version == "" {
+			found, ok = r, true
+		}
+	}
+	return found, ok
+}
+
+// goModScanner finds the modules required by a go.mod as they are built, following replace directives and skipping excluded versions.
+// A required module is reported on its require line, or on the line of the directive replacing it if that changed,
+// and replacements of modules which aren't required directly are reported on their own line.
+// Indirect requirements which the go.sum, if given, only lists for their go.mod are skipped as their code isn't built.
+func goModScanner(sum map[goModule]bool) func(lines []patchLine) map[int64]component {
+	return func(lines []patchLine) map[int64]component {
+		mod := parseGoMod(lines)
+		added := addedLines(lines)
+
+		found := make(map[int64]component)
+		replaced := make(map[int64]bool)
+		for _, req := range mod.requires {
+			if mod.excludes[req.goModule] {
+				continue
+			}
+			module, candidates := req.goModule, []int64{req.line}
+			if r, ok := mod.replacement(req.goModule); ok {
+				replaced[r.line] = true
+				// Modules replaced by a local directory aren't downloaded from anywhere IQ knows of
+				if r.to.version == "" {
+					continue
+				}
+				module, candidates = r.to, []int64{r.line, req.line}
+			}
+			if !reGoVersion.MatchString(module.version) {
+				continue
+			}
+			if built, listed := sum[module]; req.indirect && listed && !built {
+				log.Printf("TRACE: skipping %s@%s as only its go.mod is needed\n", module.path, module.version)
+				continue
+			}
+			reportOnFirstAdded(found, added, candidates, golangComponent(module.path, module.version))
+		}
+
+		for _, r := range mod.replaces {
+			if replaced[r.line] || !reGoVersion.MatchString(r.to.version) {
+				continue
+			}
+			reportOnFirstAdded(found, added, []int64{r.line}, golangComponent(r.to.path, r.to.version))
+		}
+		return found
+	}
+}
+
+// goSumModules reads which module versions a go.sum lists and whether their code is needed to build, rather than only their go.mod
+func goSumModules(content string) map[goModule]bool {
+	built := make(map[goModule]bool)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		version := strings.TrimSuffix(fields[1], "/go.mod")
+		module := goModule{fields[0], version}
+		built[module] = built[module] || version == fields[1]
+	}
+	return built
+}
+
+// componentsFromGoMod finds the modules changed by a go.mod, enriched by the go.sum next to it when it can be retrieved.
+// go.sum itself is never commented on as its changes follow from those to go.mod.
+func componentsFromGoMod(f changedFile, src manifestSource) (map[changeLocation]component, error) {
+	var sum map[goModule]bool
+	if src.content != nil {
+		sumFilename := path.Join(path.Dir(f.Filename), "go.sum")
+		content, err := src.content(sumFilename)
+		if err != nil {
+			log.Printf("TRACE: could not get content of %s, reviewing every module: %v\n", sumFilename, err)
+		} else {
+			sum = goSumModules(content)
+		}
+	}
+	return fromFileLines(goModScanner(sum))(f, src)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testGoMod = `module github.com/example/app
+
+go 1.17
+
+require github.com/pkg/errors v0.9.1
+
+require (
+	github.com/shirou/gopsutil v2.19.9+incompatible
+	github.com/syncthing/notify v0.0.0-20190709140112-69c7a957d3e2
+	github.com/sirupsen/logrus v1.8.1 // indirect
+	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
+	github.com/example/lib v1.0.0
+	github.com/gorilla/mux v1.8.0
+)
+
+exclude github.com/gorilla/mux v1.8.0
+
+replace github.com/example/lib => ../lib
+
+replace (
+	github.com/syncthing/notify v0.0.0-20190709140112-69c7a957d3e2 => github.com/example/notify v0.1.0
+	golang.org/x/net => golang.org/x/net v0.7.0
+)`
+
+const testGoSum = `github.com/pkg/errors v0.9.1 h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=
+github.com/pkg/errors v0.9.1/go.mod h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=
+github.com/sirupsen/logrus v1.8.1 h1:dJKuHgqk1NNQlqoA6BTlM1Wf9DOH3NBjQyu0h9+AZZE=
+github.com/sirupsen/logrus v1.8.1/go.mod h1:yWOB1SBYBC5VeMP7gHvWumXLIWorT60ONWic61uBYv0=
+golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c/go.mod h1:h1NjWce9XRLGQEsW7wpKNCjG9DtNlClVuFLEZdDNbEs=`
+
+func Test_componentsFromGoMod(t *testing.T) {
+	tests := []struct {
+		name  string
+		added []int
+		sum   string
+		want  map[int64]component
+	}{
+		{
+			"single line require",
+			[]int{5}, testGoSum,
+			map[int64]component{5: component{format: "golang", group: "github.com/pkg", name: "errors", version: "v0.9.1"}},
+		},
+		{
+			"incompatible and indirect requirements",
+			[]int{8, 10}, testGoSum,
+			map[int64]component{
+				8:  component{format: "golang", group: "github.com/shirou", name: "gopsutil", version: "v2.19.9+incompatible"},
+				10: component{format: "golang", group: "github.com/sirupsen", name: "logrus", version: "v1.8.1"},
+			},
+		},
+		{
+			"indirect requirement only needed for its go.mod",
+			[]int{11}, testGoSum,
+			map[int64]component{},
+		},
+		{
+			"indirect requirement without go.sum",
+			[]int{11}, "",
+			map[int64]component{11: component{format: "golang", group: "golang.org/x", name: "sys", version: "v0.0.0-20210630005230-0f9fa26af87c"}},
+		},
+		{
+			"replaced, local and excluded requirements",
+			[]int{9, 12, 13},
+			testGoSum,
+			map[int64]component{9: component{format: "golang", group: "github.com/example", name: "notify", version: "v0.1.0"}},
+		},
+		{
+			"replacements changed",
+			[]int{21, 22}, testGoSum,
+			map[int64]component{
+				21: component{format: "golang", group: "github.com/example", name: "notify", version: "v0.1.0"},
+				22: component{format: "golang", group: "golang.org/x", name: "net", version: "v0.7.0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := func(filename string) (string, error) {
+				switch {
+				case filename == "api/go.mod":
+					return testGoMod, nil
+				case filename == "api/go.sum" && tt.sum != "":
+					return tt.sum, nil
+				}
+				return "", errors.New("not found")
+			}
+			checkAddedComponents(t, "api/go.mod", testGoMod, tt.added, manifestSource{content: content}, tt.want)
+		})
+	}
+}
+
+func Test_componentsFromGoMod_patchOnly(t *testing.T) {
+	patch := `@@ -33,6 +33,8 @@ require (
+ 	github.com/satori/go.uuid v1.2.0 // indirect
+ 	github.com/shirou/gopsutil v2.19.9+incompatible // indirect
+-	github.com/spf13/cobra v0.0.5 // indirect
++	github.com/spf13/cobra v0.0.6 // indirect
++	github.com/syncthing/syncthing v0.10.26
+ 	go.uber.org/atomic v1.4.0 // indirect
+ )
++
++replace github.com/satori/go.uuid => github.com/gofrs/uuid v4.0.0+incompatible`
+	f := changedFile{Filename: "go.mod", Patch: patch}
+	want := map[changeLocation]component{
+		changeLocation{Position: 4, Line: 35}: component{format: "golang", group: "github.com/spf13", name: "cobra", version: "v0.0.6"},
+		changeLocation{Position: 5, Line: 36}: component{format: "golang", group: "github.com/syncthing", name: "syncthing", version: "v0.10.26"},
+		changeLocation{Position: 9, Line: 40}: component{format: "golang", group: "github.com/gofrs", name: "uuid", version: "v4.0.0+incompatible"},
+	}
+
+	got, err := componentsFromGoMod(f, manifestSource{})
+	if err != nil {
+		t.Fatalf("componentsFromGoMod() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("componentsFromGoMod()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+
+	if parse := manifestParserFor("go.sum"); parse != nil {
+		t.Error("manifestParserFor(go.sum) should not find a parser as go.sum is never commented on")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// gradleVariables are the variables of a build script, or the versions of a version catalog, by name
+type gradleVariables map[string]lineValue
+
+const gradleValue = `(?:"([^"]*)"|'([^']*)'|([A-Za-z_][\w.]*(?:\[["'][\w.-]+["']\])?))`
+
+var (
+	// Variables as set by ext and extra, or local to the script
+	reGradleAssignment = regexp.MustCompile(`^\s*(?:(?:const\s+)?val\s+|var\s+|def\s+|String\s+)?(?:(?:rootProject\.|project\.)?(?:ext|extra)\.)?([A-Za-z_]\w*)\s*=\s*(?:"([^"]*)"|'([^']*)')\s*;?\s*$`)
+	reGradleIndexed    = regexp.MustCompile(`^\s*(?:(?:rootProject\.|project\.)?(?:ext|extra))\s*\[\s*["']([\w.-]+)["']\s*\]\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	reGradleSet        = regexp.MustCompile(`\bset\s*\(\s*["']([\w.-]+)["']\s*,\s*(?:"([^"]*)"|'([^']*)')\s*\)`)
+	reGradleByExtra    = regexp.MustCompile(`^\s*val\s+([A-Za-z_]\w*)(?:\s*:\s*String)?\s+by\s+extra\s*\(\s*"([^"]*)"()\s*\)`)
+	reGradleMapEntry   = regexp.MustCompile(`^\s*([A-Za-z_][\w-]*|"[\w.-]+"|'[\w.-]+')\s*:\s*(?:"([^"]*)"|'([^']*)')\s*,?\s*(\]\s*)?$`)
+	reGradleCatalogVer = regexp.MustCompile(`^\s*version\s*\(\s*["']([\w.-]+)["']\s*,\s*(?:"([^"]*)"|'([^']*)')\s*\)`)
+
+	// Dependencies given as a group:name:version string, optionally as a platform
+	reGradleNotation = regexp.MustCompile(`^\s*[A-Za-z_]\w*\s*\(?\s*(?:(?:platform|enforcedPlatform)\s*\(\s*)?["']([^"':\s]+):([^"':\s]+):((?:\$\{[^}]*\}|[^"'@:\s])+)(?::[^"'@\s]*)?(?:@\w+)?["']`)
+	// Dependencies given by named arguments in either DSL, e.g. group: 'g', name: 'n', version: 'v' or group = "g", name = "n", version = "v"
+	reGradleGroup   = regexp.MustCompile(`\bgroup\s*[:=]\s*` + gradleValue)
+	reGradleName    = regexp.MustCompile(`\bname\s*[:=]\s*` + gradleValue)
+	reGradleVersion = regexp.MustCompile(`\bversion\s*[:=]\s*` + gradleValue)
+	// Plugins, which are resolved through their marker artifact
+	reGradlePlugin       = regexp.MustCompile(`^\s*id\s*\(?\s*["']([^"']+)["']\s*\)?\s+version\s*\(?\s*` + gradleValue)
+	reGradleKotlinPlugin = regexp.MustCompile(`^\s*kotlin\s*\(\s*"([^"]+)"\s*\)\s+version\s*\(?\s*` + gradleValue)
+	// Libraries of a version catalog declared in a settings script
+	reGradleLibrary         = regexp.MustCompile(`\blibrary\s*\(\s*["'][\w.-]+["']\s*,\s*["']([^"':]+)["']\s*,\s*["']([^"':]+)["']\s*\)\s*\.\s*version(Ref)?\s*\(\s*` + gradleValue)
+	reGradleLibraryNotation = regexp.MustCompile(`\blibrary\s*\(\s*["'][\w.-]+["']\s*,\s*["']([^"':\s]+):([^"':\s]+):([^"':\s]+)["']\s*\)`)
+
+	reGradleInterpolation = regexp.MustCompile(`\$\{([^}]+)\}|\$([A-Za-z_]\w*)`)
+	reGradleIndex         = regexp.MustCompile(`(?:\[|\bproperty\()\s*["']([\w.-]+)["']\s*[\])]$`)
+)
+
+// gradleString returns the string matched by the value alternatives of a regex starting at the given group,
+// keeping identifiers as references to be resolved, e.g. ${springVersion}
+func gradleString(m []string, i int) string {
+	if m[i+2] != "" {
+		return "${" + m[i+2] + "}"
+	}
+	return m[i] + m[i+1]
+}
+
+// lookup finds a variable by the expression referencing it,
+// e.g. springVersion, ext.springVersion, versions.spring, extra["springVersion"] or property("springVersion")
+func (v gradleVariables) lookup(expr string) (lineValue, bool) {
+	expr = strings.TrimSpace(expr)
+	if m := reGradleIndex.FindStringSubmatch(expr); m != nil {
+		expr = m[1]
+	}
+	if variable, ok := v[expr]; ok {
+		return variable, true
+	}
+	variable, ok := v[expr[strings.LastIndex(expr, ".")+1:]]
+	return variable, ok
+}
+
+// resolve interpolates the variables in a value, returning the lines of the variables it used.
+// Returns false if a variable isn't known, e.g. because it is in gradle.properties or another script.
+func (v gradleVariables) resolve(value string) (string, []int64, bool) {
+	return interpolate(value, reGradleInterpolation, v.lookup)
+}
+
+// isGradleDynamicVersion checks for versions which don't resolve to a single release, e.g. 1.+, latest.release or ranges
+func isGradleDynamicVersion(version string) bool {
+	return version == "" || strings.ContainsAny(version, "+$[]()") || strings.HasPrefix(version, "latest.")
+}
+
+// gradleScriptVariables finds the variables a build or settings script sets to string literals
+func gradleScriptVariables(lines []patchLine) gradleVariables {
+	vars := make(gradleVariables)
+	for _, l := range lines {
+		for _, re := range []*regexp.Regexp{reGradleAssignment, reGradleIndexed, reGradleByExtra, reGradleMapEntry, reGradleCatalogVer, reGradleSet} {
+			if m := re.FindStringSubmatch(l.text); m != nil {
+				vars[strings.Trim(m[1], `"'`)] = lineValue{m[2] + m[3], l.location.Line}
+				break
+			}
+		}
+	}
+	return vars
+}
+
+// gradlePluginMarker returns the coordinates of a plugin's marker artifact, which is a POM depending on the plugin's implementation
+func gradlePluginMarker(id string) (group, name, kind string) {
+	return id, id + ".gradle.plugin", "pom"
+}
+
+// gradleDependency finds the coordinates and version expression of a dependency or plugin declared on a line of a script.
+// The kind is the Maven type of the artifact, which is empty for jars.
+func gradleDependency(line string) (group, name, kind, version string, versionRef, ok bool) {
+	if m := reGradleNotation.FindStringSubmatch(line); m != nil {
+		return m[1], m[2], "", m[3], false, true
+	}
+	if m := reGradlePlugin.FindStringSubmatch(line); m != nil {
+		group, name, kind = gradlePluginMarker(m[1])
+		return group, name, kind, gradleString(m, 2), false, true
+	}
+	if m := reGradleKotlinPlugin.FindStringSubmatch(line); m != nil {
+		group, name, kind = gradlePluginMarker("org.jetbrains.kotlin." + m[1])
+		return group, name, kind, gradleString(m, 2), false, true
+	}
+	if m := reGradleLibraryNotation.FindStringSubmatch(line); m != nil {
+		return m[1], m[2], "", m[3], false, true
+	}
+	if m := reGradleLibrary.FindStringSubmatch(line); m != nil {
+		return m[1], m[2], "", gradleString(m, 4), m[3] != "", true
+	}
+
+	g, n := reGradleGroup.FindStringSubmatch(line), reGradleName.FindStringSubmatch(line)
+	if g == nil || n == nil {
+		return "", "", "", "", false, false
+	}
+	if v := reGradleVersion.FindStringSubmatch(line); v != nil {
+		version = gradleString(v, 1)
+	}
+	return gradleString(g, 1), gradleString(n, 1), "", version, false, true
+}
+
+// componentsFromGradle finds the dependencies and plugins of a build.gradle(.kts) or settings.gradle(.kts).
+// Versions may use the variables the script sets with ext, extra or local variables, and
+// a component is reported on its own line or, if that wasn't changed, on the line of the variable setting its version.
+func componentsFromGradle(lines []patchLine) map[int64]component {
+	vars := gradleScriptVariables(lines)
+	added := addedLines(lines)
+
+	found := make(map[int64]component)
+	for _, l := range lines {
+		group, name, kind, version, versionRef, ok := gradleDependency(l.text)
+		if !ok || version == "" {
+			continue
+		}
+		if versionRef {
+			version = "${" + strings.Trim(version, "${}") + "}"
+		}
+
+		var (
+			candidates    = []int64{l.location.Line}
+			variableLines []int64
+		)
+		group, _, ok = vars.resolve(group)
+		if !ok {
+			continue
+		}
+		name, _, ok = vars.resolve(name)
+		if !ok {
+			continue
+		}
+		version, variableLines, ok = vars.resolve(version)
+		if !ok || isGradleDynamicVersion(version) {
+			continue
+		}
+		candidates = append(candidates, variableLines...)
+
+		reportOnFirstAdded(found, added, candidates, component{format: "maven", group: group, name: name, version: version, kind: kind})
+	}
+	return found
+}
+
+var (
+	reCatalogField   = regexp.MustCompile(`\b(module|group|name|id|version\.ref|version)\s*=\s*"([^"]*)"`)
+	reCatalogVersion = regexp.MustCompile(`\b(?:strictly|require|prefer)\s*=\s*"([^"]*)"`)
+)
+
+// componentsFromVersionCatalog finds the libraries and plugins of a Gradle version catalog, e.g. gradle/libs.versions.toml.
+// A component is reported on its own line or, if that wasn't changed, on the line in [versions] which sets its version.
+func componentsFromVersionCatalog(lines []patchLine) map[int64]component {
+	vars := make(gradleVariables)
+	var table string
+	for _, l := range lines {
+		if t, ok := tomlTable(l.text); ok {
+			table = t
+			continue
+		}
+		key, value, ok := tomlKeyValue(l.text)
+		if !ok || table != "versions" {
+			continue
+		}
+		if strings.HasPrefix(value, "{") {
+			if m := reCatalogVersion.FindStringSubmatch(value); m != nil {
+				vars[key] = lineValue{m[1], l.location.Line}
+			}
+			continue
+		}
+		vars[key] = lineValue{tomlSpec(value), l.location.Line}
+	}
+
+	found := make(map[int64]component)
+	added := addedLines(lines)
+	table = ""
+	for _, l := range lines {
+		if t, ok := tomlTable(l.text); ok {
+			table = t
+			continue
+		}
+		if table != "libraries" && table != "plugins" {
+			continue
+		}
+		_, value, ok := tomlKeyValue(l.text)
+		if !ok {
+			continue
+		}
+
+		fields := make(map[string]string)
+		if strings.HasPrefix(value, "{") {
+			for _, m := range reCatalogField.FindAllStringSubmatch(value, -1) {
+				fields[m[1]] = m[2]
+			}
+			if m := reCatalogVersion.FindStringSubmatch(value); m != nil && fields["version"] == "" {
+				fields["version"] = m[1]
+			}
+		} else {
+			notation := strings.Split(tomlSpec(value), ":")
+			if table == "plugins" && len(notation) == 2 {
+				fields["id"], fields["version"] = notation[0], notation[1]
+			}
+			if table == "libraries" && len(notation) == 3 {
+				fields["module"], fields["version"] = notation[0]+":"+notation[1], notation[2]
+			}
+		}
+
+		var group, name, kind string
+		switch {
+		case table == "plugins":
+			group, name, kind = gradlePluginMarker(fields["id"])
+		case fields["module"] != "":
+			module := strings.SplitN(fields["module"], ":", 2)
+			if len(module) != 2 {
+				continue
+			}
+			group, name = module[0], module[1]
+		default:
+			group, name = fields["group"], fields["name"]
+		}
+		if group == "" || name == "" {
+			continue
+		}
+
+		candidates := []int64{l.location.Line}
+		version := fields["version"]
+		if ref := fields["version.ref"]; ref != "" {
+			v, ok := vars[ref]
+			if !ok {
+				continue
+			}
+			version = v.text
+			candidates = append(candidates, v.line)
+		}
+		if isGradleDynamicVersion(version) {
+			continue
+		}
+
+		reportOnFirstAdded(found, added, candidates, component{format: "maven", group: group, name: name, version: version, kind: kind})
+	}
+	return found
+}
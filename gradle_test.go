@@ -0,0 +1,230 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testBuildGradle = `plugins {
+    id 'org.springframework.boot' version '2.6.1'
+    id 'java'
+}
+
+ext {
+    jacksonVersion = '2.13.0'
+    versions = [
+        guava: '31.0-jre',
+    ]
+}
+ext.commonsIoVersion = '2.6'
+
+dependencies {
+    implementation 'org.apache.commons:commons-lang3:3.12.0'
+    implementation "com.fasterxml.jackson.core:jackson-databind:${jacksonVersion}"
+    implementation "com.fasterxml.jackson.core:jackson-core:$jacksonVersion"
+    implementation group: 'commons-io', name: 'commons-io', version: commonsIoVersion
+    implementation "com.google.guava:guava:${versions.guava}"
+    implementation platform('org.springframework.cloud:spring-cloud-dependencies:2021.0.0')
+    implementation 'org.springframework.boot:spring-boot-starter-web'
+    implementation 'org.slf4j:slf4j-api:1.7.+'
+    implementation "org.example:from-properties:${undefinedVersion}"
+}`
+
+const testBuildGradleKts = `plugins {
+    kotlin("jvm") version "1.6.0"
+    id("org.springframework.boot") version "2.6.1"
+}
+
+val jacksonVersion by extra("2.13.0")
+extra["junitVersion"] = "5.8.1"
+val okhttpVersion = "4.9.3"
+
+dependencies {
+    implementation("com.fasterxml.jackson.core:jackson-databind:$jacksonVersion")
+    testImplementation("org.junit.jupiter:junit-jupiter:${property("junitVersion")}")
+    testImplementation("org.junit.jupiter:junit-jupiter-api:${extra["junitVersion"]}")
+    implementation(group = "com.squareup.okhttp3", name = "okhttp", version = okhttpVersion)
+    implementation(group = "io.netty", name = "netty-all", version = "4.1.70.Final")
+}`
+
+const testSettingsGradleKts = `pluginManagement {
+    plugins {
+        id("com.google.cloud.tools.jib") version "3.1.4"
+    }
+}
+
+dependencyResolutionManagement {
+    versionCatalogs {
+        create("libs") {
+            version("spring", "5.3.20")
+            library("spring-core", "org.springframework", "spring-core").versionRef("spring")
+            library("guava", "com.google.guava", "guava").version("31.0-jre")
+            library("commons-io", "commons-io:commons-io:2.6")
+        }
+    }
+}
+
+rootProject.name = "app"`
+
+const testVersionCatalog = `[versions]
+spring = "5.3.20"
+jackson = { strictly = "2.13.0" }
+slf4j = { strictly = "[1.7, 1.8[", prefer = "1.7.32" }
+
+[libraries]
+spring-core = { module = "org.springframework:spring-core", version.ref = "spring" }
+spring-web = { group = "org.springframework", name = "spring-web", version.ref = "spring" }
+jackson-databind = { module = "com.fasterxml.jackson.core:jackson-databind", version.ref = "jackson" }
+guava = "com.google.guava:guava:31.0-jre"
+commons-io = { module = "commons-io:commons-io", version = "2.6" }
+slf4j-api = { module = "org.slf4j:slf4j-api", version.ref = "slf4j" }
+netty = { module = "io.netty:netty-all" }
+
+[bundles]
+spring = ["spring-core", "spring-web"]
+
+[plugins]
+spring-boot = { id = "org.springframework.boot", version = "2.6.1" }
+jib = "com.google.cloud.tools.jib:3.1.4"`
+
+func Test_componentsFromGradle(t *testing.T) {
+	maven := func(group, name, version string) component {
+		return component{format: "maven", group: group, name: name, version: version}
+	}
+	plugin := func(id, version string) component {
+		return component{format: "maven", group: id, name: id + ".gradle.plugin", version: version, kind: "pom"}
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		added    []int
+		want     map[int64]component
+	}{
+		{
+			"groovy plugin",
+			"build.gradle", testBuildGradle, []int{2},
+			map[int64]component{2: plugin("org.springframework.boot", "2.6.1")},
+		},
+		{
+			"groovy string notation",
+			"build.gradle", testBuildGradle, []int{15},
+			map[int64]component{15: maven("org.apache.commons", "commons-lang3", "3.12.0")},
+		},
+		{
+			"groovy ext variable bumped",
+			"build.gradle", testBuildGradle, []int{7},
+			map[int64]component{7: maven("com.fasterxml.jackson.core", "jackson-databind", "2.13.0")},
+		},
+		{
+			"groovy interpolated dependencies added",
+			"build.gradle", testBuildGradle, []int{16, 17},
+			map[int64]component{
+				16: maven("com.fasterxml.jackson.core", "jackson-databind", "2.13.0"),
+				17: maven("com.fasterxml.jackson.core", "jackson-core", "2.13.0"),
+			},
+		},
+		{
+			"groovy named arguments with variable",
+			"build.gradle", testBuildGradle, []int{12, 18},
+			map[int64]component{18: maven("commons-io", "commons-io", "2.6")},
+		},
+		{
+			"groovy ext map",
+			"build.gradle", testBuildGradle, []int{9},
+			map[int64]component{9: maven("com.google.guava", "guava", "31.0-jre")},
+		},
+		{
+			"groovy platform, managed, dynamic and unknown versions",
+			"build.gradle", testBuildGradle, []int{20, 21, 22, 23},
+			map[int64]component{20: maven("org.springframework.cloud", "spring-cloud-dependencies", "2021.0.0")},
+		},
+		{
+			"kotlin plugins",
+			"build.gradle.kts", testBuildGradleKts, []int{2, 3},
+			map[int64]component{
+				2: plugin("org.jetbrains.kotlin.jvm", "1.6.0"),
+				3: plugin("org.springframework.boot", "2.6.1"),
+			},
+		},
+		{
+			"kotlin extra variables bumped",
+			"build.gradle.kts", testBuildGradleKts, []int{6, 7},
+			map[int64]component{
+				6: maven("com.fasterxml.jackson.core", "jackson-databind", "2.13.0"),
+				7: maven("org.junit.jupiter", "junit-jupiter", "5.8.1"),
+			},
+		},
+		{
+			"kotlin named arguments",
+			"build.gradle.kts", testBuildGradleKts, []int{8, 15},
+			map[int64]component{
+				8:  maven("com.squareup.okhttp3", "okhttp", "4.9.3"),
+				15: maven("io.netty", "netty-all", "4.1.70.Final"),
+			},
+		},
+		{
+			"settings plugin and catalog",
+			"settings.gradle.kts", testSettingsGradleKts, []int{3, 10, 12, 13},
+			map[int64]component{
+				3:  plugin("com.google.cloud.tools.jib", "3.1.4"),
+				10: maven("org.springframework", "spring-core", "5.3.20"),
+				12: maven("com.google.guava", "guava", "31.0-jre"),
+				13: maven("commons-io", "commons-io", "2.6"),
+			},
+		},
+		{
+			"catalog version bumped",
+			"gradle/libs.versions.toml", testVersionCatalog, []int{2, 3, 4},
+			map[int64]component{
+				2: maven("org.springframework", "spring-core", "5.3.20"),
+				3: maven("com.fasterxml.jackson.core", "jackson-databind", "2.13.0"),
+			},
+		},
+		{
+			"catalog libraries and plugins added",
+			"gradle/libs.versions.toml", testVersionCatalog, []int{8, 10, 11, 12, 13, 16, 19, 20},
+			map[int64]component{
+				8:  maven("org.springframework", "spring-web", "5.3.20"),
+				10: maven("com.google.guava", "guava", "31.0-jre"),
+				11: maven("commons-io", "commons-io", "2.6"),
+				19: plugin("org.springframework.boot", "2.6.1"),
+				20: plugin("com.google.cloud.tools.jib", "3.1.4"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := func(string) (string, error) { return tt.content, nil }
+			checkAddedComponents(t, tt.filename, tt.content, tt.added, manifestSource{content: content}, tt.want)
+		})
+	}
+}
+
+func Test_componentsFromGradle_patchOnly(t *testing.T) {
+	f := changedFile{Filename: "build.gradle", Patch: dummyPatches["build.gradle"]}
+	want := map[changeLocation]component{
+		changeLocation{Position: 4, Line: 14}: component{format: "maven", group: "axis", name: "axis", version: "1.2.1"},
+		changeLocation{Position: 9, Line: 18}: component{format: "maven", group: "commons-fileupload", name: "commons-fileupload", version: "1.2.2"},
+	}
+
+	got, err := manifestParserFor(f.Filename)(f, manifestSource{})
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("parse()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+}
+
+func Test_gradlePluginMarker_purl(t *testing.T) {
+	group, name, kind := gradlePluginMarker("org.springframework.boot")
+	c := component{format: "maven", group: group, name: name, version: "2.6.1", kind: kind}
+	want := "pkg:maven/org.springframework.boot/org.springframework.boot.gradle.plugin@2.6.1?type=pom"
+	if got := c.purl(); got != want {
+		t.Errorf("purl() = %s, want %s", got, want)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/package-url/packageurl-go"
@@ -32,12 +34,67 @@ func httpreq(method, url string, payload io.Reader) (*http.Response, error) {
 	return client.Do(request)
 }
 
-func getComponentRemediations(iq nexusiq.IQ, nexusApplication string, manifests componentRemediations) (componentRemediations, error) {
-	asIQComponent := func(c component) (nexusiq.Component, error) {
-		// TODO: how bout errors and validation?
-		return nexusiq.Component{PackageURL: c.purl()}, nil
+// iqApplications maps directories of a repository to the IQ application the manifests under them are evaluated against.
+// The "" entry is used for manifests outside of any mapped directory.
+type iqApplications map[string]string
+
+// newIQApplications creates the mapping with the given default application and directory overrides
+func newIQApplications(defaultApp string, directories map[string]string) iqApplications {
+	apps := iqApplications{"": defaultApp}
+	for dir, app := range directories {
+		dir = strings.Trim(path.Clean("/"+dir), "/")
+		apps[dir] = app
+	}
+	return apps
+}
+
+// forManifest returns the application of the closest mapped directory containing the manifest
+func (a iqApplications) forManifest(filename string) string {
+	dir := strings.Trim(path.Dir(path.Clean("/"+filename)), "/")
+	for dir != "" {
+		if app, ok := a[dir]; ok {
+			return app
+		}
+		dir = strings.Trim(path.Dir("/"+dir), "/")
 	}
+	return a[""]
+}
+
+// iqMatchStateUnknown is the match state of components IQ has no information on
+const iqMatchStateUnknown = "unknown"
+
+// remediationFor finds the component IQ recommends instead of the given one.
+// If IQ has no remediation and does not know the component, its alternate package URLs are tried in turn,
+// e.g. the wheels of a pypi package which doesn't publish a source distribution.
+func remediationFor(iq nexusiq.IQ, c component, app string) (nexusiq.Component, error) {
+	purls := append([]string{c.purl()}, c.alternatePurls()...)
+	for i, purl := range purls {
+		iqcomponent := nexusiq.Component{PackageURL: purl}
+		log.Printf("TRACE: evaluating %s component: %v\n", app, iqcomponent)
+
+		remediation, err := nexusiq.GetRemediationByApp(iq, iqcomponent, nexusiq.StageBuild, app)
+		if err != nil {
+			return nexusiq.Component{}, fmt.Errorf("could not evaluate component %v: %v", iqcomponent, err)
+		}
+
+		rcomp, err := remediation.ComponentForRemediationType(nexusiq.RemediationTypeNoViolations)
+		if err == nil {
+			return rcomp, nil
+		}
+		if i == len(purls)-1 {
+			return nexusiq.Component{}, err
+		}
 
+		details, derr := nexusiq.GetComponent(iq, iqcomponent)
+		if derr != nil || details.MatchState != iqMatchStateUnknown {
+			return nexusiq.Component{}, err
+		}
+		log.Printf("TRACE: IQ does not know %s, retrying as %s\n", purl, purls[i+1])
+	}
+	return nexusiq.Component{}, errors.New("component has no package URL")
+}
+
+func getComponentRemediations(iq nexusiq.IQ, apps iqApplications, manifests componentRemediations) (componentRemediations, error) {
 	asComponent := func(c nexusiq.Component) (component, error) {
 		log.Printf("TRACE: asComponent(): %#v\n", c)
 
@@ -48,21 +105,26 @@ func getComponentRemediations(iq nexusiq.IQ, nexusApplication string, manifests
 			if err != nil {
 				return component{}, fmt.Errorf("could not parse PackageURL: %v", err)
 			}
+			if purl.Type == "golang" {
+				// IQ splits a module path at its last segment, even if it is a major version suffix
+				return golangComponent(strings.TrimPrefix(purl.Namespace+"/"+purl.Name, "/"), purl.Version), nil
+			}
 			return component{
-				purl.Type,
-				purl.Namespace,
-				purl.Name,
-				purl.Version,
+				format:  purl.Type,
+				group:   purl.Namespace,
+				name:    purl.Name,
+				version: purl.Version,
+				kind:    purl.Qualifiers.Map()["type"],
 			}, nil
 
 		case c.ComponentID != nil:
 			log.Printf("TRACE: CID: %#v\n", c.ComponentID)
 			log.Printf("TRACE: C.PURL: %s\n", c.PackageURL)
 			return component{
-				c.ComponentID.Format,
-				c.ComponentID.Coordinates.GroupID,
-				c.ComponentID.Coordinates.ArtifactID,
-				c.ComponentID.Coordinates.Version,
+				format:  c.ComponentID.Format,
+				group:   c.ComponentID.Coordinates.GroupID,
+				name:    c.ComponentID.Coordinates.ArtifactID,
+				version: c.ComponentID.Coordinates.Version,
 			}, nil
 		}
 
@@ -72,31 +134,21 @@ func getComponentRemediations(iq nexusiq.IQ, nexusApplication string, manifests
 	remediations := make(componentRemediations)
 
 	for m, components := range manifests {
-		log.Printf("TRACE: evaluating manifest: %s\n", m.Filename)
+		nexusApplication := apps.forManifest(m.Filename)
+		log.Printf("TRACE: evaluating manifest %s against IQ application %s\n", m.Filename, nexusApplication)
 		remediated := make(map[changeLocation]component)
 		log.Printf("TRACE: manifest components: %v\n", components)
 		for loc, c := range components {
-			iqcomponent, _ := asIQComponent(c)
-			log.Printf("TRACE: evaluating %s component for manifest %s: %v\n", nexusApplication, m.Filename, iqcomponent)
-
 			log.Println("TRACE: retrieving remediating component")
-			remediation, err := nexusiq.GetRemediationByApp(iq, iqcomponent, nexusiq.StageBuild, nexusApplication)
-			if err != nil {
-				log.Printf("ERROR: could not evaluate component %v: %v\n", iqcomponent, err)
-				continue
-			}
-
-			rcomp, err := remediation.ComponentForRemediationType(nexusiq.RemediationTypeNoViolations)
+			rcomp, err := remediationFor(iq, c, nexusApplication)
 			if err != nil {
-				log.Printf("WARN: did not find remediating component for %v: %v\n", iqcomponent, err)
-				log.Printf("TRACE: remediation: %v\n", remediation)
+				log.Printf("WARN: did not find remediating component for %s: %v\n", c.purl(), err)
 				continue
 			}
 
 			comp, err := asComponent(rcomp)
 			if err != nil {
 				log.Printf("ERROR: could not parse remediating component object %v: %v\n", rcomp, err)
-				log.Printf("TRACE: remediation: %v\n", remediation)
 				continue
 			}
 
@@ -107,7 +159,7 @@ func getComponentRemediations(iq nexusiq.IQ, nexusApplication string, manifests
 
 			// TODO: evaluate the component to determine what is wrong with it
 
-			log.Printf("TRACE: adding suggestion: %v[%#v] = %v\n", iqcomponent, loc, comp)
+			log.Printf("TRACE: adding suggestion: %s[%#v] = %v\n", c.purl(), loc, comp)
 			remediated[loc] = comp
 		}
 
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	nexusiq "github.com/sonatype-nexus-community/gonexus/iq"
+)
+
+func Test_iqApplications_forManifest(t *testing.T) {
+	apps := newIQApplications("monorepo", map[string]string{
+		"services/api":    "api",
+		"./services/web/": "web",
+		"/libs":           "libs",
+	})
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"package.json", "monorepo"},
+		{"services/api/package.json", "api"},
+		{"services/api/vendor/pom.xml", "api"},
+		{"services/web/Gemfile", "web"},
+		{"services/worker/go.mod", "monorepo"},
+		{"libs/core/build.gradle", "libs"},
+		{"/libs/requirements.txt", "libs"},
+		{"services/apiv2/package.json", "monorepo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := apps.forManifest(tt.filename); got != tt.want {
+				t.Errorf("forManifest(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getComponentRemediations_pypiWheel(t *testing.T) {
+	const wheel = "pkg:pypi/flask@2.3.2?extension=whl&qualifier=py3-none-any"
+
+	var evaluated []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/applications":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"applications": []map[string]string{{"id": "app-internal-id", "publicId": r.URL.Query().Get("publicId")}},
+			})
+		case "/api/v2/components/remediation/application/app-internal-id":
+			var c nexusiq.Component
+			json.NewDecoder(r.Body).Decode(&c)
+			evaluated = append(evaluated, c.PackageURL)
+
+			// Only the wheel is known, so only it gets a remediation
+			resp := map[string]interface{}{"remediation": map[string]interface{}{"versionChanges": []interface{}{}}}
+			if c.PackageURL == wheel {
+				resp["remediation"] = map[string]interface{}{
+					"versionChanges": []interface{}{map[string]interface{}{
+						"type": nexusiq.RemediationTypeNoViolations,
+						"data": map[string]interface{}{"component": map[string]string{"packageUrl": "pkg:pypi/flask@2.3.3?extension=whl&qualifier=py3-none-any"}},
+					}},
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		case "/api/v2/components/details":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"componentDetails": []map[string]string{{"matchState": iqMatchStateUnknown}},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	iq, err := nexusiq.New(srv.URL, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := changedFile{Filename: "requirements.txt"}
+	loc := changeLocation{Position: 1, Line: 1}
+	manifests := componentRemediations{manifest: {loc: component{format: "pypi", name: "flask", version: "2.3.2"}}}
+
+	got, err := getComponentRemediations(iq, newIQApplications("app", nil), manifests)
+	if err != nil {
+		t.Fatalf("getComponentRemediations() error = %v", err)
+	}
+
+	want := []string{"pkg:pypi/flask@2.3.2?extension=tar.gz", wheel}
+	if len(evaluated) != len(want) || evaluated[0] != want[0] || evaluated[1] != want[1] {
+		t.Errorf("evaluated %v, want %v", evaluated, want)
+	}
+	if c := got[manifest][loc]; c.version != "2.3.3" {
+		t.Errorf("getComponentRemediations() = %v, want version 2.3.3", got)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -12,61 +15,149 @@ import (
 	nexusiq "github.com/sonatype-nexus-community/gonexus/iq"
 )
 
-func handleLambdaEvent(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	requestResponse := func(statusCode int, message string) events.APIGatewayProxyResponse {
-		return events.APIGatewayProxyResponse{
-			StatusCode: statusCode,
-			Body:       message,
+// getHeader looks up an HTTP header without regard to case as API Gateway and net/http normalize them differently
+func getHeader(headers map[string]string, key string) (string, bool) {
+	if v, ok := headers[key]; ok {
+		return v, true
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v, true
 		}
 	}
+	return "", false
+}
+
+// newClients looks up the configuration for the repository the event is for and creates the IQ and SCM clients with it
+func newClients(cfg config, query map[string]string, payload []byte) (nexusiq.IQ, scmConnection, repoConfig, error) {
+	webhookRepo, err := repositoryFromPayload(payload)
+	if err != nil {
+		return nil, scmConnection{}, repoConfig{}, err
+	}
 
-	token := req.QueryStringParameters["token"]
-	iqApp := req.QueryStringParameters["iq_app"]
-	iqURL := req.QueryStringParameters["iq_url"]
-	iqAuth := strings.Split(req.QueryStringParameters["iq_auth"], ":")
+	repo := cfg.fromQuery(cfg.forRepository(webhookRepo.host, webhookRepo.name), query)
+	if repo.IQURL == "" {
+		return nil, scmConnection{}, repo, fmt.Errorf("no IQ server configured for repository %s/%s", webhookRepo.host, webhookRepo.name)
+	}
+
+	iq, err := nexusiq.New(repo.IQURL, repo.IQUsername, repo.IQPassword)
+	if err != nil {
+		return nil, scmConnection{}, repo, fmt.Errorf("could not create IQ client: %v", err)
+	}
+	log.Printf("TRACE: created client to IQ server for %s/%s as: %s\n", webhookRepo.host, webhookRepo.name, repo.IQApp)
 
-	iq, err := nexusiq.New(iqURL, iqAuth[0], iqAuth[1])
+	scm, err := newSCMConnection(cfg, webhookRepo, repo.Token)
 	if err != nil {
-		err2 := fmt.Errorf("could not create IQ client: %v", err)
-		log.Printf("ERROR: %v", err2)
-		return requestResponse(http.StatusInternalServerError, err2.Error()), err2
+		return nil, scmConnection{}, repo, fmt.Errorf("could not create client for %s: %v", webhookRepo.host, err)
 	}
-	log.Printf("TRACE: created client to IQ server as: %s\n", iqApp)
 
+	return iq, scm, repo, nil
+}
+
+// handleWebhookEvent routes a Github or Gitlab webhook to the appropriate handler.
+// Returns the http status code and message to respond with.
+func handleWebhookEvent(cfg config, headers, query map[string]string, body []byte) (int, string, error) {
 	// Github webhook comes in two parts.
 	// One is a ping to verify the connection
 	// The other is the actual event
-	supported, status := IsValidGithubWebhookPullRequestEvent(req.Headers)
+	supported, status := IsValidGithubWebhookPullRequestEvent(headers)
+	if status == http.StatusOK {
+		switch {
+		case cfg.GithubWebhookSecret != "":
+			if err := verifyGithubWebhookSignature(headers, body, cfg.GithubWebhookSecret); err != nil {
+				log.Printf("WARN: rejecting Github webhook: %v", err)
+				return http.StatusUnauthorized, "Could not verify Github webhook signature", nil
+			}
+		case cfg.AllowUnverifiedWebhooks:
+			log.Println("WARN: no Github webhook secret configured; not verifying Github webhook signature")
+		default:
+			// An unverified payload chooses which server the SCM token is sent to, so it is never processed by default
+			log.Println("WARN: rejecting Github webhook as no Github webhook secret is configured")
+			return http.StatusUnauthorized, "No Github webhook secret configured", nil
+		}
+	}
+
 	switch {
 	case !supported && status == http.StatusOK:
-		return requestResponse(status, "Acknowledging ping"), nil
+		return status, "Acknowledging ping", nil
 	case !supported && status != http.StatusOK:
 		log.Println("WARN: Did not receive a valid Github webhook")
 		// We don't return here in case what we got was a Gitlab webhook
 	case supported:
-		status, err := HandleGithubWebhookPullRequestEvent(iq, iqApp, token, []byte(req.Body))
+		iq, scm, repo, err := newClients(cfg, query, body)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			return requestResponse(status, err.Error()), err
+			return http.StatusInternalServerError, err.Error(), err
 		}
-		return requestResponse(status, "Evaluating new Github pull request"), nil
+		status, err := HandleGithubWebhookPullRequestEvent(iq, repo, scm, body)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			return status, err.Error(), err
+		}
+		return status, "Evaluating new Github pull request", nil
 	}
 
 	// Gitlab's webhook event is simpler. Just need a quick binary check
-	supported, status = IsValidGitlabWebhookMergeRequestEvent(req.Headers)
+	supported, status = IsValidGitlabWebhookMergeRequestEvent(headers)
 	if !supported {
 		log.Println("WARN: Did not receive a valid Gitlab webhook")
-		return requestResponse(status, "Did not receive a valid Gitlab webhook"), nil
+		return status, "Did not receive a valid Gitlab webhook", nil
 	}
 
-	status, err = HandleGitlabWebhookMergeRequestEvent(iq, iqApp, token, []byte(req.Body))
+	switch {
+	case len(cfg.GitlabWebhookSecrets) > 0:
+		if err := verifyGitlabWebhookToken(headers, body, cfg.GitlabWebhookSecrets); err != nil {
+			log.Printf("WARN: rejecting Gitlab webhook: %v", err)
+			return http.StatusUnauthorized, "Could not verify Gitlab webhook token", nil
+		}
+	case cfg.AllowUnverifiedWebhooks:
+		log.Println("WARN: no Gitlab webhook secrets configured; not verifying Gitlab webhook token")
+	default:
+		log.Println("WARN: rejecting Gitlab webhook as no Gitlab webhook secrets are configured")
+		return http.StatusUnauthorized, "No Gitlab webhook secret configured", nil
+	}
+
+	iq, scm, repo, err := newClients(cfg, query, body)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return http.StatusInternalServerError, err.Error(), err
+	}
+
+	status, err = HandleGitlabWebhookMergeRequestEvent(iq, repo, scm, body)
 	if err != nil {
 		log.Printf("ERROR: %v", err)
-		return requestResponse(status, err.Error()), err
+		return status, err.Error(), err
+	}
+	return status, "Evaluating new Gitlab merge request", nil
+}
+
+func newLambdaHandler(cfg config) func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		status, message, err := handleWebhookEvent(cfg, req.Headers, req.QueryStringParameters, []byte(req.Body))
+		return events.APIGatewayProxyResponse{
+			StatusCode: status,
+			Body:       message,
+		}, err
 	}
-	return requestResponse(status, "Evaluating new Gitlab merge request"), nil
 }
 
 func main() {
-	lambda.Start(handleLambdaEvent)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("ERROR: could not load configuration: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		flags := flag.NewFlagSet("serve", flag.ExitOnError)
+		listen := flags.String("listen", envOrDefault("LISTEN_ADDR", ":8080"), "address to listen on for webhook requests")
+		shutdownTimeout := flags.Duration("shutdown-timeout", 30*time.Second, "time to wait for in-flight requests when shutting down")
+		flags.Parse(os.Args[2:])
+
+		if err := serve(cfg, *listen, *shutdownTimeout); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		return
+	}
+
+	lambda.Start(newLambdaHandler(cfg))
 }
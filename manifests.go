@@ -2,106 +2,376 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-func componentsSingleLineNameVersion(lines map[changeLocation]string, re *regexp.Regexp, format string, fields []string) (map[changeLocation]component, error) {
-	converted := make(map[changeLocation]component)
+// lineValue is the text of a value in a manifest, such as a version or a property, along with the line it is on
+type lineValue struct {
+	text string
+	line int64
+}
 
-	for p, l := range lines {
-		matches := re.FindAllStringSubmatch(l, -1)
-		if len(matches) == 0 {
-			continue
+// lineIndex returns a function which maps an offset in the content to its line number
+func lineIndex(content string) func(offset int64) int64 {
+	var newlines []int
+	for i, c := range content {
+		if c == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+	return func(offset int64) int64 {
+		return int64(sort.SearchInts(newlines, int(offset))) + 1
+	}
+}
+
+// interpolate replaces the references to variables in a value, as matched by re with the variable's name as the first non-empty group,
+// returning the lines of the variables it used. Returns false if lookup doesn't know a variable.
+func interpolate(value string, re *regexp.Regexp, lookup func(name string) (lineValue, bool)) (string, []int64, bool) {
+	var lines []int64
+	for depth := 0; re.MatchString(value); depth++ {
+		if depth > 10 {
+			return "", nil, false
 		}
-		found := matches[0]
-		c := component{format: format}
-		for i, f := range fields {
-			switch f {
-			case "group":
-				c.group = found[i+1]
-			case "name":
-				c.name = found[i+1]
-			case "version":
-				c.version = found[i+1]
+		resolved := true
+		value = re.ReplaceAllStringFunc(value, func(ref string) string {
+			var name string
+			for _, group := range re.FindStringSubmatch(ref)[1:] {
+				if group != "" {
+					name = group
+					break
+				}
 			}
+			variable, ok := lookup(name)
+			if !ok {
+				resolved = false
+				return ref
+			}
+			lines = append(lines, variable.line)
+			return variable.text
+		})
+		if !resolved {
+			return "", nil, false
 		}
-		converted[p] = c
 	}
+	return value, lines, true
+}
 
-	return converted, nil
+// npmComponent creates an npm component, splitting the scope of a scoped package into its group
+func npmComponent(name, version string) component {
+	c := component{format: "npm", name: name, version: version}
+	if i := strings.Index(name, "/"); strings.HasPrefix(name, "@") && i > 0 {
+		c.group, c.name = name[:i], name[i+1:]
+	}
+	return c
 }
 
-func componentsFromNpm(lines map[changeLocation]string) (map[changeLocation]component, error) {
-	re := regexp.MustCompile(`"([^"]*)": ".?([0-9]+(\.[0-9]+)+)",?`)
-	return componentsSingleLineNameVersion(lines, re, "npm", []string{"name", "version"})
+var reNpmDependency = regexp.MustCompile(`^(\s*"([^"]+)"\s*:\s*")([^"]*)(".*)$`)
+
+// The package.json sections which declare dependencies
+var defaultNpmSections = []string{"dependencies", "devDependencies", "optionalDependencies", "peerDependencies"}
+
+type npmDependency struct {
+	name, spec string
 }
 
-func componentsFromNuget(lines map[changeLocation]string) (map[changeLocation]component, error) {
-	re := regexp.MustCompile(`<package id="([^"]*)" version="([^"]*)"`)
-	return componentsSingleLineNameVersion(lines, re, "nuget", []string{"name", "version"})
+// npmDependencyComponent creates the component to evaluate for a dependency, or returns false if it isn't from the registry
+func npmDependencyComponent(dep npmDependency) (component, bool) {
+	// Ranges are evaluated at the lowest version they allow
+	name, version, ok := resolveNpmSpec(dep.name, dep.spec)
+	if !ok {
+		return component{}, false
+	}
+	return npmComponent(name, version.String()), true
 }
 
-func componentsFromPypi(lines map[changeLocation]string) (map[changeLocation]component, error) {
-	re := regexp.MustCompile(`(.*)==([^\s#]*)`)
-	return componentsSingleLineNameVersion(lines, re, "pypi", []string{"name", "version"})
+// packageJSONDependencies finds the dependencies declared in the given top level sections of a package.json, keyed by line number
+func packageJSONDependencies(content string, sections []string) (map[int64]npmDependency, error) {
+	reviewed := make(map[string]bool, len(sections))
+	for _, s := range sections {
+		reviewed[s] = true
+	}
+
+	lineAt := func(offset int64) int64 {
+		return int64(strings.Count(content[:offset], "\n")) + 1
+	}
+
+	dec := json.NewDecoder(strings.NewReader(content))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("package.json is not an object")
+	}
+
+	deps := make(map[int64]npmDependency)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var section json.RawMessage
+		if err := dec.Decode(&section); err != nil {
+			return nil, err
+		}
+		if !reviewed[key.(string)] {
+			continue
+		}
+		start := dec.InputOffset() - int64(len(section))
+
+		sdec := json.NewDecoder(bytes.NewReader(section))
+		if tok, err := sdec.Token(); err != nil || tok != json.Delim('{') {
+			log.Printf("WARN: package.json section %s is not an object\n", key)
+			continue
+		}
+		for sdec.More() {
+			name, err := sdec.Token()
+			if err != nil {
+				return nil, err
+			}
+			line := lineAt(start + sdec.InputOffset())
+
+			var spec interface{}
+			if err := sdec.Decode(&spec); err != nil {
+				return nil, err
+			}
+			if s, ok := spec.(string); ok {
+				deps[line] = npmDependency{name.(string), s}
+			}
+		}
+	}
+
+	return deps, nil
 }
 
-func componentsFromGomod(lines map[changeLocation]string) (map[changeLocation]component, error) {
-	re := regexp.MustCompile(`^\s*([^\s]*)\s(v[0-9+](\.[0-9]+)+(-[-0-9a-z]+)?)(\s.*)?$`)
-	return componentsSingleLineNameVersion(lines, re, "golang", []string{"name", "version"})
+// componentsFromPackageJSON finds the dependencies added to the reviewed sections of a package.json.
+// The full file is needed to know which section a line is in, as it's rarely within a patch's context.
+func componentsFromPackageJSON(f changedFile, src manifestSource) (map[changeLocation]component, error) {
+	if src.content == nil {
+		return componentsFromPackageJSONPatch(f.Patch, src.npmSections), nil
+	}
+
+	content, err := src.content(f.Filename)
+	if err != nil {
+		log.Printf("WARN: could not get content of %s, only reviewing dependencies in sections visible in the diff: %v\n", f.Filename, err)
+		return componentsFromPackageJSONPatch(f.Patch, src.npmSections), nil
+	}
+
+	deps, err := packageJSONDependencies(content, src.npmSections)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse package.json: %v", err)
+	}
+
+	components := make(map[changeLocation]component)
+	for loc := range parsePatchLineAdditions(f.Patch) {
+		if dep, ok := deps[loc.Line]; ok {
+			if c, ok := npmDependencyComponent(dep); ok {
+				components[loc] = c
+			}
+		}
+	}
+	return components, nil
 }
 
-func componentsFromRuby(lines map[changeLocation]string) (map[changeLocation]component, error) {
-	re := regexp.MustCompile(`gem\s*'([^']*)',\s*'[><~=\s]*([0-9+](\.[0-9]+)+(\.[0-9a-z]+)?)'$`)
-	comps, err := componentsSingleLineNameVersion(lines, re, "ruby", []string{"name", "version"})
+// componentsFromPackageJSONPatch finds the dependencies added to the reviewed sections of a package.json using only its patch.
+// Lines are skipped unless the start of their section is in the patch's context.
+func componentsFromPackageJSONPatch(patch string, sections []string) map[changeLocation]component {
+	reviewed := make(map[string]bool, len(sections))
+	for _, s := range sections {
+		reviewed[s] = true
+	}
+
+	reSection := regexp.MustCompile(`^\s*"([^"]+)"\s*:\s*\{`)
+	reClose := regexp.MustCompile(`^\s*\}`)
+
+	components := make(map[changeLocation]component)
+	var (
+		section  string
+		prevLine int64
+	)
+	for _, l := range parsePatchLines(patch) {
+		// A new hunk starts in an unknown section
+		if l.location.Line != prevLine+1 {
+			section = ""
+		}
+		prevLine = l.location.Line
 
-	reFill := regexp.MustCompile(`([0-9]+)(\.[0-9]+)?(\.[0-9]+)?(\.[0-9a-z]+)?`)
-	versionFill := func(v string) string {
-		ver := v
-		matches := reFill.FindAllStringSubmatch(v, -1)
-		for i, m := range matches[0] {
-			if i < 2 || i > 3 {
+		switch {
+		case reSection.MatchString(l.text):
+			section = reSection.FindStringSubmatch(l.text)[1]
+		case reClose.MatchString(l.text):
+			section = ""
+		case l.added && reviewed[section]:
+			m := reNpmDependency.FindStringSubmatch(l.text)
+			if m == nil {
 				continue
 			}
-			if m == "" {
-				ver += ".0"
+			if c, ok := npmDependencyComponent(npmDependency{m[2], m[3]}); ok {
+				components[l.location] = c
 			}
 		}
+	}
+	return components
+}
+
+// suggestNpmLine rewrites the range of a package.json dependency line to require the remediated version
+func suggestNpmLine(line, version string) string {
+	m := reNpmDependency.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	spec := suggestNpmSpec(m[3], version)
+	if spec == "" {
+		return ""
+	}
+	return m[1] + spec + m[4]
+}
+
+// componentsFromNpmLockfile finds the packages whose version changed in a package-lock.json or npm-shrinkwrap.json.
+// Lockfile version 1 nests packages by name under "dependencies" while versions 2 and 3 key them by their
+// path under "packages", e.g. "node_modules/a/node_modules/@scope/b". Version 2 has both, so only the paths are used.
+func componentsFromNpmLockfile(patch string) (map[changeLocation]component, error) {
+	reKey := regexp.MustCompile(`^\s*"([^"]+)":\s*\{\s*$`)
+	reVersion := regexp.MustCompile(`^\s*"version":\s*"([0-9][^"]*)",?\s*$`)
+	reClose := regexp.MustCompile(`^\s*\},?\s*$`)
+
+	// Properties of a package which are objects rather than packages themselves
+	properties := map[string]bool{
+		"dependencies": true, "devDependencies": true, "optionalDependencies": true, "peerDependencies": true,
+		"peerDependenciesMeta": true, "requires": true, "packages": true, "engines": true, "bin": true, "funding": true,
+	}
+
+	const nodeModules = "node_modules/"
 
-		return ver
+	lines := parsePatchLines(patch)
+
+	var byPath bool
+	for _, l := range lines {
+		if m := reKey.FindStringSubmatch(l.text); m != nil && strings.HasPrefix(m[1], nodeModules) {
+			byPath = true
+			break
+		}
 	}
 
-	for k, c := range comps {
-		c.version = versionFill(c.version)
-		comps[k] = c
+	components := make(map[changeLocation]component)
+	var key string
+	for _, l := range lines {
+		switch {
+		case reKey.MatchString(l.text):
+			key = reKey.FindStringSubmatch(l.text)[1]
+		case reClose.MatchString(l.text):
+			key = ""
+		case l.added && key != "" && !properties[key] && reVersion.MatchString(l.text):
+			name := key
+			if i := strings.LastIndex(key, nodeModules); i >= 0 {
+				name = key[i+len(nodeModules):]
+			} else if byPath {
+				// The version 1 style entry duplicated in version 2 lockfiles, or a workspace package
+				continue
+			}
+			version := reVersion.FindStringSubmatch(l.text)[1]
+			log.Printf("TRACE: lockfile package %s changed to %s\n", key, version)
+			components[l.location] = npmComponent(name, version)
+		}
 	}
 
-	return comps, err
+	return components, nil
+}
+
+// npmDescriptorName returns the package name of a lockfile descriptor such as "@scope/name@^1.0.0" or "name@npm:^1.0.0"
+func npmDescriptorName(descriptor string) string {
+	descriptor = strings.Trim(strings.TrimSpace(descriptor), `"'`)
+	// Skip the first character so the @ of a scope isn't taken for the version separator
+	if i := strings.Index(descriptor, "@"); i == 0 {
+		if j := strings.Index(descriptor[1:], "@"); j >= 0 {
+			return descriptor[:j+1]
+		}
+	} else if i > 0 {
+		return descriptor[:i]
+	}
+	return descriptor
 }
 
-func componentsFromGradle(lines map[changeLocation]string) (map[changeLocation]component, error) {
-	reOld := regexp.MustCompile(`^.*group:\s*'([^']*)',\s+name:\s*'([^']*)',\s+version:\s*'([^']*)'\s*$`)
-	reNew := regexp.MustCompile(`^[^\s(]*[\s(]["']([^:]*):([^:]*):([^:]*)["']\)?$`)
-	fields := []string{"group", "name", "version"}
+// componentsFromYarnLockfile finds the packages whose resolved version changed in a yarn.lock.
+// Handles both the custom format of yarn v1 and the YAML of Yarn Berry (v2+).
+func componentsFromYarnLockfile(patch string) (map[changeLocation]component, error) {
+	reEntry := regexp.MustCompile(`^([^\s#].*):$`)
+	reVersion := regexp.MustCompile(`^\s+version:?\s+"?([0-9][^"\s]*)"?\s*$`)
+
+	// Berry also lists workspaces and other packages which don't come from the registry
+	nonRegistry := regexp.MustCompile(`@(workspace|patch|link|portal|file|exec|git\+?[a-z]*|https?):`)
+
 	components := make(map[changeLocation]component)
-	if comps, err := componentsSingleLineNameVersion(lines, reOld, "maven", fields); err == nil {
-		for k, v := range comps {
-			if _, ok := components[k]; !ok {
-				components[k] = v
+	var entry string
+	for _, l := range parsePatchLines(patch) {
+		switch {
+		case reEntry.MatchString(l.text):
+			entry = reEntry.FindStringSubmatch(l.text)[1]
+			if entry == "__metadata" || nonRegistry.MatchString(entry) {
+				entry = ""
 			}
-			// TODO: what if it does find it?
+		case l.added && entry != "" && reVersion.MatchString(l.text):
+			// Every descriptor of an entry is for the same package, e.g. "lodash@^4.17.15, lodash@^4.17.20"
+			name := npmDescriptorName(strings.Split(entry, ",")[0])
+			version := reVersion.FindStringSubmatch(l.text)[1]
+			components[l.location] = npmComponent(name, version)
 		}
 	}
 
-	if comps, err := componentsSingleLineNameVersion(lines, reNew, "maven", fields); err == nil {
-		for k, v := range comps {
-			if _, ok := components[k]; !ok {
-				components[k] = v
+	return components, nil
+}
+
+// componentsFromPnpmLockfile finds the packages added to a pnpm-lock.yaml.
+// A package's key contains its version so a new version shows up as a new key, e.g.
+// "/lodash/4.17.21" (v5), "/@babel/core@7.12.0(supports-color@5.5.0)" (v6) or "lodash@4.17.21" (v9).
+func componentsFromPnpmLockfile(patch string) (map[changeLocation]component, error) {
+	reKey := regexp.MustCompile(`^  ['"]?/?([^\s'"]+)['"]?:\s*$`)
+	reVersion := regexp.MustCompile(`^[0-9][0-9A-Za-z.+-]*$`)
+
+	parseKey := func(key string) (string, string) {
+		// Drop the peer dependencies pnpm appends to the key
+		if i := strings.Index(key, "("); i > 0 {
+			key = key[:i]
+		}
+		// Version 5 keys separate the version with a slash and peers with an underscore
+		if i := strings.LastIndex(key, "/"); i > 0 && i+1 < len(key) && key[i+1] >= '0' && key[i+1] <= '9' {
+			version := key[i+1:]
+			if j := strings.Index(version, "_"); j > 0 {
+				version = version[:j]
 			}
-			// TODO: what if it does find it?
+			return key[:i], version
+		}
+		if i := strings.LastIndex(key, "@"); i > 0 {
+			return key[:i], key[i+1:]
+		}
+		return key, ""
+	}
+
+	components := make(map[changeLocation]component)
+	seen := make(map[component]bool)
+	for _, l := range parsePatchLines(patch) {
+		if !l.added || !reKey.MatchString(l.text) {
+			continue
+		}
+
+		name, version := parseKey(reKey.FindStringSubmatch(l.text)[1])
+		if !reVersion.MatchString(version) {
+			continue
+		}
+
+		// Version 9 repeats each package under "snapshots" so only keep the first
+		c := npmComponent(name, version)
+		if seen[c] {
+			continue
 		}
+		seen[c] = true
+		components[l.location] = c
 	}
 
 	return components, nil
@@ -112,9 +382,16 @@ func parseHunkStart(line string) []string {
 	return reHunkStart.FindStringSubmatch(line)
 }
 
-func parsePatchLineAdditions(patch string) map[changeLocation]string {
-	// log.Println(patch)
-	adds := make(map[changeLocation]string)
+// patchLine is a line of the new version of a file as seen in a patch
+type patchLine struct {
+	location changeLocation
+	text     string
+	added    bool
+}
+
+// parsePatchLines returns the context and added lines of a patch in order, skipping removed lines
+func parsePatchLines(patch string) []patchLine {
+	var lines []patchLine
 
 	scanner := bufio.NewScanner(strings.NewReader(patch))
 	var position, hunkLine int64
@@ -125,24 +402,34 @@ func parsePatchLineAdditions(patch string) map[changeLocation]string {
 			continue
 		}
 
-		// log.Printf("%d: %s\n", hunkLine, line)
-
 		switch {
 		case line == `\ No newline at end of file`:
 			fallthrough
 		case line[0] == '-':
 			hunkLine--
 		case line[0] == '+':
-			adds[changeLocation{Position: position, Line: hunkLine}] = line[1:]
+			lines = append(lines, patchLine{location: changeLocation{Position: position, Line: hunkLine}, text: line[1:], added: true})
 		case len(line) > 1 && line[:2] == "@@":
 			match := parseHunkStart(line)
 			hunkLine, _ = strconv.ParseInt(match[2], 10, 64)
 			hunkLine--
+		default:
+			lines = append(lines, patchLine{location: changeLocation{Position: position, Line: hunkLine}, text: strings.TrimPrefix(line, " ")})
 		}
 		position++
 		hunkLine++
 	}
 
+	return lines
+}
+
+func parsePatchLineAdditions(patch string) map[changeLocation]string {
+	adds := make(map[changeLocation]string)
+	for _, l := range parsePatchLines(patch) {
+		if l.added {
+			adds[l.location] = l.text
+		}
+	}
 	return adds
 }
 
@@ -202,38 +489,204 @@ func getPomComponents(patch string) (map[changeLocation]component, error) {
 	return components, nil
 }
 
-func findComponentsFromManifest(files []changedFile) (map[changedFile]map[changeLocation]component, error) {
-	getComponents := func(patch string, linesToComponents func(lines map[changeLocation]string) (map[changeLocation]component, error)) (map[changeLocation]component, error) {
+// fileContentFunc returns the full content of a file as of the head of the request
+type fileContentFunc func(filename string) (string, error)
+
+// manifestSource gives manifest parsers what they need beyond a manifest's patch
+type manifestSource struct {
+	// content is nil when full files can't be retrieved, in which case parsers make do with the patch
+	content     fileContentFunc
+	npmSections []string
+}
+
+type manifestParser func(f changedFile, src manifestSource) (map[changeLocation]component, error)
+
+// fromPatch creates a manifest parser which only needs the manifest's patch
+func fromPatch(parse func(patch string) (map[changeLocation]component, error)) manifestParser {
+	return func(f changedFile, _ manifestSource) (map[changeLocation]component, error) {
+		return parse(f.Patch)
+	}
+}
+
+// fromLineAdditions creates a manifest parser which only looks at the lines added by the patch
+func fromLineAdditions(linesToComponents func(lines map[changeLocation]string) (map[changeLocation]component, error)) manifestParser {
+	return fromPatch(func(patch string) (map[changeLocation]component, error) {
 		additions := parsePatchLineAdditions(patch)
 		return linesToComponents(additions)
+	})
+}
+
+// manifestLines returns the numbered lines of the manifest's full content, or of its patch if that isn't available
+func manifestLines(f changedFile, src manifestSource) []patchLine {
+	if src.content != nil {
+		content, err := src.content(f.Filename)
+		if err == nil {
+			added := make(map[int64]bool)
+			for loc := range parsePatchLineAdditions(f.Patch) {
+				added[loc.Line] = true
+			}
+			var lines []patchLine
+			for i, text := range strings.Split(content, "\n") {
+				line := int64(i + 1)
+				lines = append(lines, patchLine{location: changeLocation{Line: line}, text: strings.TrimSuffix(text, "\r"), added: added[line]})
+			}
+			return lines
+		}
+		log.Printf("WARN: could not get content of %s, only reviewing what is visible in the diff: %v\n", f.Filename, err)
+	}
+	return parsePatchLines(f.Patch)
+}
+
+// fromFileLines creates a manifest parser for formats where a line's meaning depends on the lines before it, e.g. its section.
+// The scan is given the whole file when available, otherwise each contiguous run of lines in the patch in turn.
+// Only the components it finds on lines added by the patch are kept.
+func fromFileLines(scan func(lines []patchLine) map[int64]component) manifestParser {
+	return func(f changedFile, src manifestSource) (map[changeLocation]component, error) {
+		found := make(map[int64]component)
+		lines := manifestLines(f, src)
+		for start, i := 0, 1; i <= len(lines); i++ {
+			if i < len(lines) && lines[i].location.Line == lines[i-1].location.Line+1 {
+				continue
+			}
+			for line, c := range scan(lines[start:i]) {
+				found[line] = c
+			}
+			start = i
+		}
+
+		components := make(map[changeLocation]component)
+		for loc := range parsePatchLineAdditions(f.Patch) {
+			if c, ok := found[loc.Line]; ok {
+				components[loc] = c
+			}
+		}
+		return components, nil
+	}
+}
+
+// addedLines returns the set of lines which were added by the patch
+func addedLines(lines []patchLine) map[int64]bool {
+	added := make(map[int64]bool)
+	for _, l := range lines {
+		if l.added {
+			added[l.location.Line] = true
+		}
+	}
+	return added
+}
+
+// reportOnFirstAdded reports a component on the first of the lines which set it that was added, unless another component is already there
+func reportOnFirstAdded(found map[int64]component, added map[int64]bool, candidates []int64, c component) {
+	for _, line := range candidates {
+		if !added[line] {
+			continue
+		}
+		if _, taken := found[line]; !taken {
+			found[line] = c
+		}
+		return
+	}
+}
+
+// manifestParsers associates glob patterns of manifest file names with the parser for them.
+// Patterns are matched against as many trailing path segments as they have, so manifests are found in any directory.
+var manifestParsers = []struct {
+	pattern string
+	parse   manifestParser
+}{
+	{"pom.xml", componentsFromPom},
+	{"build.gradle", fromFileLines(componentsFromGradle)},
+	{"build.gradle.kts", fromFileLines(componentsFromGradle)},
+	{"settings.gradle", fromFileLines(componentsFromGradle)},
+	{"settings.gradle.kts", fromFileLines(componentsFromGradle)},
+	{"gradle/*.versions.toml", fromFileLines(componentsFromVersionCatalog)},
+	{"package.json", componentsFromPackageJSON},
+	{"package-lock.json", fromPatch(componentsFromNpmLockfile)},
+	{"npm-shrinkwrap.json", fromPatch(componentsFromNpmLockfile)},
+	{"yarn.lock", fromPatch(componentsFromYarnLockfile)},
+	{"pnpm-lock.yaml", fromPatch(componentsFromPnpmLockfile)},
+	{"packages.config", fromLineAdditions(componentsFromNuget)},
+	{"*.csproj", componentsFromMSBuildProject},
+	{"*.fsproj", componentsFromMSBuildProject},
+	{"*.vbproj", componentsFromMSBuildProject},
+	{"Directory.Packages.props", componentsFromMSBuildProject},
+	{"Directory.Build.props", componentsFromMSBuildProject},
+	{"packages.lock.json", fromFileLines(componentsFromNugetLockfile)},
+	{"paket.dependencies", fromLineAdditions(componentsFromPaketDependencies)},
+	{"paket.lock", fromFileLines(componentsFromPaketLock)},
+	{"*requirements*.txt", fromLineAdditions(componentsFromPypi)},
+	{"*requirements*.in", fromLineAdditions(componentsFromPypi)},
+	{"requirements/*.txt", fromLineAdditions(componentsFromPypi)},
+	{"requirements/*.in", fromLineAdditions(componentsFromPypi)},
+	{"Pipfile", fromFileLines(componentsFromPipfile)},
+	{"Pipfile.lock", fromFileLines(componentsFromPipfileLock)},
+	{"poetry.lock", fromFileLines(componentsFromPoetryLock)},
+	{"pyproject.toml", fromFileLines(componentsFromPyproject)},
+	{"go.mod", componentsFromGoMod},
+	{"Gemfile", rubyManifestParser(reGemDeclaration)},
+	{"gems.rb", rubyManifestParser(reGemDeclaration)},
+	{"*.gemspec", rubyManifestParser(reGemspecDep)},
+	{"Gemfile.lock", fromFileLines(componentsFromGemfileLock)},
+	{"gems.locked", fromFileLines(componentsFromGemfileLock)},
+	{"Cargo.toml", fromFileLines(componentsFromCargoToml)},
+	{"Cargo.lock", fromFileLines(componentsFromCargoLock)},
+}
+
+// lockfiles are generated by package managers so suggesting edits to them would be pointless
+var lockfiles = map[string]bool{
+	"package-lock.json":   true,
+	"npm-shrinkwrap.json": true,
+	"yarn.lock":           true,
+	"pnpm-lock.yaml":      true,
+	"Pipfile.lock":        true,
+	"poetry.lock":         true,
+	"packages.lock.json":  true,
+	"paket.lock":          true,
+	"Gemfile.lock":        true,
+	"gems.locked":         true,
+	"Cargo.lock":          true,
+}
+
+func isLockfile(filename string) bool {
+	return lockfiles[path.Base(filename)]
+}
+
+// matchManifestPattern checks the trailing path segments of a file name against a manifest pattern
+func matchManifestPattern(pattern, filename string) bool {
+	segments := strings.Split(strings.TrimPrefix(filename, "/"), "/")
+	if n := strings.Count(pattern, "/") + 1; n < len(segments) {
+		segments = segments[len(segments)-n:]
+	}
+	matched, _ := path.Match(pattern, strings.Join(segments, "/"))
+	return matched
+}
+
+// manifestParserFor returns the parser for the given file or nil if it is not a known manifest
+func manifestParserFor(filename string) manifestParser {
+	for _, m := range manifestParsers {
+		if matchManifestPattern(m.pattern, filename) {
+			return m.parse
+		}
 	}
+	return nil
+}
 
+func findComponentsFromManifest(files []changedFile, src manifestSource) (map[changedFile]map[changeLocation]component, error) {
 	manifests := make(map[changedFile]map[changeLocation]component, 0)
+	included := requirementsIncludes(files, src)
 
 	for _, f := range files {
-		components := make(map[changeLocation]component)
-		var err error
-		switch f.Filename {
-		case "pom.xml":
-			components, err = getPomComponents(f.Patch)
-		case "build.gradle":
-			components, err = getComponents(f.Patch, componentsFromGradle)
-		case "package.json":
-			components, err = getComponents(f.Patch, componentsFromNpm)
-		case "packages.config":
-			components, err = getComponents(f.Patch, componentsFromNuget)
-		case "requirements.txt":
-			components, err = getComponents(f.Patch, componentsFromPypi)
-		case "go.sum":
-			fallthrough
-		case "go.mod":
-			components, err = getComponents(f.Patch, componentsFromGomod)
-		case "Gemfile":
-			components, err = getComponents(f.Patch, componentsFromRuby)
+		parse := manifestParserFor(f.Filename)
+		if parse == nil && included[f.Filename] {
+			parse = fromLineAdditions(componentsFromPypi)
+		}
+		if parse == nil {
+			continue
 		}
 
+		components, err := parse(f, src)
 		if err != nil {
-			// TODO
+			log.Printf("WARN: could not parse manifest %s: %v\n", f.Filename, err)
 			continue
 		}
 
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -147,6 +150,125 @@ github.com/syndtr/goleveldb v1.0.1-0.20190318030020-c3a204f8e965/go.mod h1:9OrXJ
  gem 'fast_blank', '~> 1.0'
  gem 'fastimage'
  gem 'goldfinger', '~> 2.1'`,
+	"package-lock.json": `@@ -1,6 +1,6 @@
+ {
+   "name": "app",
+-  "version": "1.0.0",
++  "version": "1.0.1",
+   "lockfileVersion": 2,
+   "requires": true,
+@@ -120,9 +120,9 @@
+       }
+     },
+     "node_modules/lodash": {
+-      "version": "4.17.15",
+-      "resolved": "https://registry.npmjs.org/lodash/-/lodash-4.17.15.tgz",
++      "version": "4.17.21",
++      "resolved": "https://registry.npmjs.org/lodash/-/lodash-4.17.21.tgz",
+       "dev": true
+     },
+     "node_modules/express/node_modules/@types/qs": {
+-      "version": "6.9.0",
++      "version": "6.9.7",
+       "license": "MIT"
+     },
+@@ -900,7 +900,7 @@
+       }
+     },
+     "lodash": {
+-      "version": "4.17.15",
++      "version": "4.17.21",
+       "dev": true
+     },`,
+	"npm-shrinkwrap.json": `@@ -10,12 +10,12 @@
+       "integrity": "sha512-abc"
+     },
+     "minimist": {
+-      "version": "0.0.8",
++      "version": "1.2.6",
+       "resolved": "https://registry.npmjs.org/minimist/-/minimist-1.2.6.tgz",
+       "requires": {
+-        "version": "^1.0.0"
++        "version": "^1.0.1"
+       }
+     },
+     "@babel/core": {
+-      "version": "7.0.0",
++      "version": "7.12.0",
+       "dev": true
+     },`,
+	"yarn.lock": `@@ -100,10 +100,10 @@
+   dependencies:
+     ms "2.1.2"
+ 
+-lodash@^4.17.15:
+-  version "4.17.15"
+-  resolved "https://registry.yarnpkg.com/lodash/-/lodash-4.17.15.tgz"
++lodash@^4.17.15, lodash@^4.17.21:
++  version "4.17.21"
++  resolved "https://registry.yarnpkg.com/lodash/-/lodash-4.17.21.tgz"
+ 
+ "@babel/core@^7.0.0":
+-  version "7.0.0"
++  version "7.12.0"
+   dependencies:`,
+	"yarn.lock (berry)": `@@ -1,6 +1,6 @@
+ __metadata:
+-  version: 6
++  version: 8
+   cacheKey: 8
+ 
+ "app@workspace:.":
+@@ -20,8 +20,8 @@
+   languageName: node
+   linkType: hard
+ 
+ "@types/qs@npm:^6.9.0":
+-  version: 6.9.0
+-  resolution: "@types/qs@npm:6.9.0"
++  version: 6.9.7
++  resolution: "@types/qs@npm:6.9.7"
+   checksum: abc
+   languageName: node
+   linkType: hard`,
+	"pnpm-lock.yaml": `@@ -50,11 +50,11 @@ packages:
+     dev: false
+ 
+-  /lodash/4.17.15:
++  /lodash/4.17.21:
+     resolution: {integrity: sha512-abc}
+     dev: false
+ 
+-  /@babel/core/7.0.0_supports-color@5.5.0:
++  /@babel/core/7.12.0_supports-color@5.5.0:
+     resolution: {integrity: sha512-def}
+     dependencies:
+-      lodash: 4.17.15
++      lodash: 4.17.21`,
+	"pnpm-lock.yaml (v6)": `@@ -5,8 +5,8 @@ settings:
+ dependencies:
+   lodash:
+     specifier: ^4.17.15
+-    version: 4.17.15
++    version: 4.17.21
+ 
+ packages:
+ 
+-  /@babel/core@7.0.0(supports-color@5.5.0):
++  /@babel/core@7.12.0(supports-color@5.5.0):
+     resolution: {integrity: sha512-def}`,
+	"pnpm-lock.yaml (v9)": `@@ -20,7 +20,7 @@ packages:
+ 
+-  lodash@4.17.15:
++  lodash@4.17.21:
+     resolution: {integrity: sha512-abc}
+ 
+@@ -60,6 +60,6 @@ snapshots:
+ 
+-  lodash@4.17.15: {}
++  lodash@4.17.21:
+ 
+   '@types/qs@6.9.7': {}`,
 }
 
 func TestParsePatchAdditions(t *testing.T) {
@@ -247,3 +369,320 @@ func Test_getPomComponents(t *testing.T) {
 		})
 	}
 }
+
+func Test_manifestParserFor(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     bool
+	}{
+		{"pom.xml", true},
+		{"backend/pom.xml", true},
+		{"services/api/package.json", true},
+		{"/services/web/Gemfile", true},
+		{"services/api/package.json.bak", false},
+		{"requirements-dev.txt", true},
+		{"api/dev-requirements.in", true},
+		{"api/requirements/base.txt", true},
+		{"api/requirements/base.pip", false},
+		{"requirements.txt.orig", false},
+		{"MANIFEST.in", false},
+		{"Pipfile.lock", true},
+		{"backend/pyproject.toml", true},
+		{"app/build.gradle.kts", true},
+		{"settings.gradle", true},
+		{"gradle/libs.versions.toml", true},
+		{"libs.versions.toml", false},
+		{"src/App/App.csproj", true},
+		{"Directory.Packages.props", true},
+		{"src/App/packages.lock.json", true},
+		{"paket.dependencies", true},
+		{"App.csproj.user", false},
+		{"crates/api/Cargo.toml", true},
+		{"Cargo.lock", true},
+		{"docs/pom.xml.md", false},
+		{"README.md", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := manifestParserFor(tt.filename); (got != nil) != tt.want {
+				t.Errorf("manifestParserFor(%q) found = %v, want %v", tt.filename, got != nil, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findComponentsFromManifest(t *testing.T) {
+	pom := changedFile{Filename: "backend/pom.xml", Patch: dummyPatches["pom.xml"]}
+	gemfile := changedFile{Filename: "services/web/Gemfile", Patch: dummyPatches["Gemfile"]}
+	readme := changedFile{Filename: "README.md", Patch: "@@ -1,1 +1,1 @@\n-old\n+new"}
+
+	// The Gemfile's pessimistic ~> 4.3 is evaluated at the version Gemfile.lock resolved it to
+	content := func(filename string) (string, error) {
+		if filename == "services/web/Gemfile.lock" {
+			return "GEM\n  remote: https://rubygems.org/\n  specs:\n    doorkeeper (4.3.2)\n      railties (>= 4.2)\n", nil
+		}
+		return "", errors.New("not found")
+	}
+
+	got, err := findComponentsFromManifest([]changedFile{pom, gemfile, readme}, manifestSource{content: content})
+	if err != nil {
+		t.Fatalf("findComponentsFromManifest() error = %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Errorf("findComponentsFromManifest() found %d manifests, want 2", len(got))
+	}
+	if len(got[pom]) != 4 {
+		t.Errorf("findComponentsFromManifest() found %d components in %s, want 4", len(got[pom]), pom.Filename)
+	}
+	want := map[changeLocation]component{
+		changeLocation{Position: 5, Line: 47}: component{format: "ruby", name: "doorkeeper", version: "4.3.2"},
+	}
+	if !reflect.DeepEqual(got[gemfile], want) {
+		t.Errorf("findComponentsFromManifest() = %v, want %v", got[gemfile], want)
+	}
+}
+
+func Test_componentsFromNpmLockfile(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  map[changeLocation]component
+	}{
+		{
+			"lockfile version 2",
+			dummyPatches["package-lock.json"],
+			map[changeLocation]component{
+				changeLocation{Position: 13, Line: 123}: component{format: "npm", name: "lodash", version: "4.17.21"},
+				changeLocation{Position: 19, Line: 128}: component{format: "npm", group: "@types", name: "qs", version: "6.9.7"},
+			},
+		},
+		{
+			"lockfile version 1",
+			dummyPatches["npm-shrinkwrap.json"],
+			map[changeLocation]component{
+				changeLocation{Position: 5, Line: 13}:  component{format: "npm", name: "minimist", version: "1.2.6"},
+				changeLocation{Position: 14, Line: 20}: component{format: "npm", group: "@babel", name: "core", version: "7.12.0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := componentsFromNpmLockfile(tt.patch)
+			if err != nil {
+				t.Fatalf("componentsFromNpmLockfile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error("componentsFromNpmLockfile()")
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+}
+
+func Test_npmJavascriptLockfiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(patch string) (map[changeLocation]component, error)
+		patch string
+		want  map[changeLocation]component
+	}{
+		{
+			"yarn v1",
+			componentsFromYarnLockfile,
+			dummyPatches["yarn.lock"],
+			map[changeLocation]component{
+				changeLocation{Position: 8, Line: 104}:  component{format: "npm", name: "lodash", version: "4.17.21"},
+				changeLocation{Position: 13, Line: 108}: component{format: "npm", group: "@babel", name: "core", version: "7.12.0"},
+			},
+		},
+		{
+			"yarn berry",
+			componentsFromYarnLockfile,
+			dummyPatches["yarn.lock (berry)"],
+			map[changeLocation]component{
+				changeLocation{Position: 14, Line: 24}: component{format: "npm", group: "@types", name: "qs", version: "6.9.7"},
+			},
+		},
+		{
+			"pnpm v5",
+			componentsFromPnpmLockfile,
+			dummyPatches["pnpm-lock.yaml"],
+			map[changeLocation]component{
+				changeLocation{Position: 4, Line: 52}: component{format: "npm", name: "lodash", version: "4.17.21"},
+				changeLocation{Position: 9, Line: 56}: component{format: "npm", group: "@babel", name: "core", version: "7.12.0"},
+			},
+		},
+		{
+			"pnpm v6",
+			componentsFromPnpmLockfile,
+			dummyPatches["pnpm-lock.yaml (v6)"],
+			map[changeLocation]component{
+				changeLocation{Position: 10, Line: 12}: component{format: "npm", group: "@babel", name: "core", version: "7.12.0"},
+			},
+		},
+		{
+			"pnpm v9",
+			componentsFromPnpmLockfile,
+			dummyPatches["pnpm-lock.yaml (v9)"],
+			map[changeLocation]component{
+				changeLocation{Position: 3, Line: 21}: component{format: "npm", name: "lodash", version: "4.17.21"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.patch)
+			if err != nil {
+				t.Fatalf("%s error = %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error(tt.name)
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+}
+
+// newFilePatch creates the patch of a new file with the given content, so every line is added
+func newFilePatch(content string) string {
+	lines := strings.Split(content, "\n")
+	return fmt.Sprintf("@@ -0,0 +1,%d @@\n+%s", len(lines), strings.Join(lines, "\n+"))
+}
+
+// patchAddingLines creates a patch which adds the given lines of the content, e.g. those changed by a pull request
+func patchAddingLines(content string, added ...int) string {
+	isAdded := make(map[int]bool)
+	for _, l := range added {
+		isAdded[l] = true
+	}
+
+	lines := strings.Split(content, "\n")
+	patch := fmt.Sprintf("@@ -1,%d +1,%d @@", len(lines)-len(added), len(lines))
+	for i, l := range lines {
+		prefix := " "
+		if isAdded[i+1] {
+			prefix = "+"
+		}
+		patch += "\n" + prefix + l
+	}
+	return patch
+}
+
+// checkAddedComponents parses a manifest whose given lines were added and checks the components found, keyed by line.
+// src provides the full content of the manifest, and of any other file its parser reads, at the request's head commit.
+func checkAddedComponents(t *testing.T, filename, content string, added []int, src manifestSource, want map[int64]component) {
+	t.Helper()
+
+	parse := manifestParserFor(filename)
+	if parse == nil {
+		t.Fatalf("manifestParserFor(%q) = nil", filename)
+	}
+	f := changedFile{Filename: filename, Patch: patchAddingLines(content, added...)}
+	got, err := parse(f, src)
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+
+	wantLocations := make(map[changeLocation]component)
+	for line, c := range want {
+		wantLocations[changeLocation{Position: line, Line: line}] = c
+	}
+	if !reflect.DeepEqual(got, wantLocations) {
+		t.Error("parse()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", wantLocations)
+	}
+}
+
+const testPackageJSON = `{
+  "name": "app",
+  "version": "1.0.0",
+  "engines": {
+    "node": ">=10"
+  },
+  "scripts": {
+    "test": "mocha"
+  },
+  "dependencies": {
+    "chalk": "^1.0.0",
+    "lodash": ">= 4.17.0 <5",
+    "@babel/core": "~7.12.x",
+    "underscore": "npm:lodash@4.17.21",
+    "local": "file:../local",
+    "next": "canary"
+  },
+  "devDependencies": {
+    "mocha": "^8.0.0"
+  }
+}`
+
+func Test_componentsFromPackageJSON(t *testing.T) {
+	f := changedFile{Filename: "package.json", Patch: newFilePatch(testPackageJSON)}
+	content := func(filename string) (string, error) {
+		if filename != f.Filename {
+			t.Errorf("requested content of %s", filename)
+		}
+		return testPackageJSON, nil
+	}
+
+	dependencies := map[changeLocation]component{
+		changeLocation{Position: 11, Line: 11}: component{format: "npm", name: "chalk", version: "1.0.0"},
+		changeLocation{Position: 12, Line: 12}: component{format: "npm", name: "lodash", version: "4.17.0"},
+		changeLocation{Position: 13, Line: 13}: component{format: "npm", group: "@babel", name: "core", version: "7.12.0"},
+		changeLocation{Position: 14, Line: 14}: component{format: "npm", name: "lodash", version: "4.17.21"},
+	}
+	all := map[changeLocation]component{
+		changeLocation{Position: 19, Line: 19}: component{format: "npm", name: "mocha", version: "8.0.0"},
+	}
+	for loc, c := range dependencies {
+		all[loc] = c
+	}
+
+	tests := []struct {
+		name string
+		src  manifestSource
+		want map[changeLocation]component
+	}{
+		{"default sections", manifestSource{content: content, npmSections: defaultNpmSections}, all},
+		{"only dependencies", manifestSource{content: content, npmSections: []string{"dependencies"}}, dependencies},
+		{"without content", manifestSource{npmSections: defaultNpmSections}, all},
+		{
+			"content unavailable",
+			manifestSource{content: func(string) (string, error) { return "", errors.New("not found") }, npmSections: defaultNpmSections},
+			all,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := componentsFromPackageJSON(f, tt.src)
+			if err != nil {
+				t.Fatalf("componentsFromPackageJSON() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error("componentsFromPackageJSON()")
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+
+	if got := suggestNpmLine(` "chalk": "^1.0.0",`, "2.4.2"); got != ` "chalk": "^2.4.2",` {
+		t.Errorf("suggestNpmLine() = %q", got)
+	}
+}
+
+func Test_componentsFromPackageJSONPatch(t *testing.T) {
+	// The last hunk's section is unknown as its start is not in the context
+	got := componentsFromPackageJSONPatch(dummyPatches["package.json"], defaultNpmSections)
+	want := map[changeLocation]component{
+		changeLocation{Position: 4, Line: 78}: component{format: "npm", name: "chalk", version: "1.0.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("componentsFromPackageJSONPatch()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+}
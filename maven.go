@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+)
+
+type pomDependency struct {
+	groupID, artifactID, version, scope, kind lineValue
+	// managed is true for the entries of <dependencyManagement> and <pluginManagement>, which set the version of those elsewhere
+	managed bool
+	// plugin is true for build plugins, whose versions are managed separately from those of dependencies
+	plugin bool
+}
+
+// key identifies a dependency or plugin for looking up its managed version
+func (d pomDependency) key() string {
+	section := "dependency"
+	if d.plugin {
+		section = "plugin"
+	}
+	return section + ":" + d.groupID.text + ":" + d.artifactID.text
+}
+
+// pomModel is what is needed of a POM to know the versions of its dependencies
+type pomModel struct {
+	properties   map[string]lineValue
+	dependencies []pomDependency
+}
+
+// parsePom reads the properties, parent, dependencies and plugins of a POM, keeping track of the line each value is on
+func parsePom(content string) (pomModel, error) {
+	lineAt := lineIndex(content)
+
+	pom := pomModel{properties: make(map[string]lineValue)}
+
+	var (
+		stack  []string
+		text   strings.Builder
+		line   int64
+		dep    *pomDependency
+		plugin *pomDependency
+	)
+	in := func(name string) bool {
+		for _, s := range stack {
+			if s == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	dec := xml.NewDecoder(strings.NewReader(content))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return pomModel{}, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+			line = lineAt(dec.InputOffset())
+			parent := ""
+			if len(stack) > 1 {
+				parent = stack[len(stack)-2]
+			}
+			switch {
+			case t.Name.Local == "dependency" && parent == "dependencies":
+				dep = &pomDependency{managed: in("dependencyManagement") && !in("plugin")}
+			case t.Name.Local == "plugin" && parent == "plugins":
+				plugin = &pomDependency{managed: in("pluginManagement"), plugin: true}
+			case len(stack) == 2 && t.Name.Local == "parent":
+				// The parent is a POM whose dependencies and versions are inherited, so it is evaluated like one
+				dep = &pomDependency{kind: lineValue{text: "pom"}}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := lineValue{strings.TrimSpace(text.String()), line}
+			path := strings.Join(stack, "/")
+			parent := ""
+			if len(stack) > 1 {
+				parent = stack[len(stack)-2]
+			}
+			switch {
+			case strings.HasPrefix(path, "project/properties/") && len(stack) == 3:
+				pom.properties[t.Name.Local] = value
+			case path == "project/groupId", path == "project/artifactId", path == "project/version",
+				path == "project/parent/groupId", path == "project/parent/version":
+				pom.properties[strings.Replace(path, "/", ".", -1)] = value
+			}
+
+			switch {
+			case dep != nil && (t.Name.Local == "dependency" && parent == "dependencies" || path == "project/parent"):
+				// Dependencies of plugins aren't managed by <dependencyManagement>, so they can only be evaluated with their own version
+				if !in("plugin") || dep.version.text != "" {
+					pom.dependencies = append(pom.dependencies, *dep)
+				}
+				dep = nil
+			case plugin != nil && t.Name.Local == "plugin" && parent == "plugins":
+				if plugin.groupID.text == "" {
+					plugin.groupID.text = "org.apache.maven.plugins"
+				}
+				pom.dependencies = append(pom.dependencies, *plugin)
+				plugin = nil
+			case dep != nil && (parent == "dependency" || path == "project/parent/"+t.Name.Local):
+				dep.set(t.Name.Local, value)
+			case plugin != nil && parent == "plugin":
+				plugin.set(t.Name.Local, value)
+			}
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+
+	// A project inherits its group and version from its parent if it doesn't set them
+	for _, p := range []string{"groupId", "version"} {
+		if _, ok := pom.properties["project."+p]; !ok {
+			if v, ok := pom.properties["project.parent."+p]; ok {
+				pom.properties["project."+p] = v
+			}
+		}
+	}
+	// Deprecated aliases of the project's version which are still seen
+	if v, ok := pom.properties["project.version"]; ok {
+		for _, alias := range []string{"pom.version", "version"} {
+			if _, ok := pom.properties[alias]; !ok {
+				pom.properties[alias] = v
+			}
+		}
+	}
+
+	return pom, nil
+}
+
+// set assigns the value of one of the coordinates of a dependency
+func (d *pomDependency) set(element string, value lineValue) {
+	switch element {
+	case "groupId":
+		d.groupID = value
+	case "artifactId":
+		d.artifactID = value
+	case "version":
+		d.version = value
+	case "scope":
+		d.scope = value
+	case "type":
+		d.kind = value
+	}
+}
+
+var rePomProperty = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// resolve replaces the properties in a value, returning the lines of the properties it used.
+// Returns false if a property isn't defined in the POM, e.g. because it comes from a parent POM.
+func (p pomModel) resolve(value string) (string, []int64, bool) {
+	return interpolate(value, rePomProperty, func(name string) (lineValue, bool) {
+		prop, ok := p.properties[name]
+		return prop, ok
+	})
+}
+
+// changedComponents returns the dependencies, plugins, parent and imported BOMs whose version was changed by the added lines.
+// Each is reported on the line which changed it: its version, a property its version uses, or the dependency itself if it is new.
+// When one property sets the version of several dependencies, the first of them is reported.
+func (p pomModel) changedComponents(added map[int64]changeLocation) map[changeLocation]component {
+	managed := make(map[string]pomDependency)
+	for _, d := range p.dependencies {
+		if d.managed {
+			managed[d.key()] = d
+		}
+	}
+
+	components := make(map[changeLocation]component)
+	for _, d := range p.dependencies {
+		// Only BOMs can be imported, and only into <dependencyManagement>
+		if d.scope.text == "import" && (!d.managed || d.kind.text != "pom") {
+			continue
+		}
+
+		group, _, ok := p.resolve(d.groupID.text)
+		if !ok {
+			continue
+		}
+		name, _, ok := p.resolve(d.artifactID.text)
+		if !ok {
+			continue
+		}
+
+		// Dependencies and plugins without a version get it from their management section, which is reported on its own if it changed
+		var candidates []int64
+		versionValue := d.version
+		if versionValue.text == "" {
+			md, ok := managed[d.key()]
+			if !ok || d.managed {
+				continue
+			}
+			versionValue = md.version
+		} else {
+			candidates = append(candidates, d.version.line)
+		}
+
+		version, propertyLines, ok := p.resolve(versionValue.text)
+		if !ok || version == "" || strings.HasPrefix(version, "[") || strings.HasPrefix(version, "(") {
+			continue
+		}
+		if d.version.text != "" {
+			candidates = append(candidates, propertyLines...)
+		}
+		candidates = append(candidates, d.artifactID.line, d.groupID.line)
+
+		var kind string
+		if d.kind.text == "pom" {
+			kind = "pom"
+		}
+
+		for _, line := range candidates {
+			loc, ok := added[line]
+			if !ok {
+				continue
+			}
+			if _, taken := components[loc]; !taken {
+				components[loc] = component{format: "maven", group: group, name: name, version: version, kind: kind}
+			}
+			break
+		}
+	}
+	return components
+}
+
+// componentsFromPom finds the dependencies changed in a pom.xml.
+// The full file is needed to resolve properties, so without it only literal versions on added lines are found.
+func componentsFromPom(f changedFile, src manifestSource) (map[changeLocation]component, error) {
+	if src.content == nil {
+		return pomComponentsFromPatch(f.Patch)
+	}
+
+	content, err := src.content(f.Filename)
+	if err != nil {
+		log.Printf("WARN: could not get content of %s, only reviewing literal versions in the diff: %v\n", f.Filename, err)
+		return pomComponentsFromPatch(f.Patch)
+	}
+
+	pom, err := parsePom(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pom.xml: %v", err)
+	}
+
+	added := make(map[int64]changeLocation)
+	for loc := range parsePatchLineAdditions(f.Patch) {
+		added[loc.Line] = loc
+	}
+	return pom.changedComponents(added), nil
+}
+
+// pomComponentsFromPatch finds the dependencies with a version added by the patch, skipping those which use properties
+func pomComponentsFromPatch(patch string) (map[changeLocation]component, error) {
+	components, err := getPomComponents(patch)
+	if err != nil {
+		return nil, err
+	}
+	for loc, c := range components {
+		if strings.Contains(c.version, "${") {
+			delete(components, loc)
+		}
+	}
+	return components, nil
+}
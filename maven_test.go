@@ -0,0 +1,289 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testPom = `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0">
+  <modelVersion>4.0.0</modelVersion>
+  <groupId>com.example</groupId>
+  <artifactId>app</artifactId>
+  <version>1.0.0</version>
+
+  <properties>
+    <spring.version>5.3.20</spring.version>
+    <jackson.version>2.13.0</jackson.version>
+  </properties>
+
+  <dependencyManagement>
+    <dependencies>
+      <dependency>
+        <groupId>com.fasterxml.jackson.core</groupId>
+        <artifactId>jackson-databind</artifactId>
+        <version>${jackson.version}</version>
+      </dependency>
+    </dependencies>
+  </dependencyManagement>
+
+  <dependencies>
+    <dependency>
+      <groupId>org.springframework</groupId>
+      <artifactId>spring-core</artifactId>
+      <version>${spring.version}</version>
+    </dependency>
+    <dependency>
+      <groupId>org.springframework</groupId>
+      <artifactId>spring-web</artifactId>
+      <version>${spring.version}</version>
+    </dependency>
+    <dependency>
+      <groupId>com.fasterxml.jackson.core</groupId>
+      <artifactId>jackson-databind</artifactId>
+    </dependency>
+    <dependency>
+      <groupId>${project.groupId}</groupId>
+      <artifactId>app-common</artifactId>
+      <version>${project.version}</version>
+    </dependency>
+    <dependency>
+      <groupId>commons-io</groupId>
+      <artifactId>commons-io</artifactId>
+      <version>2.6</version>
+      <exclusions>
+        <exclusion>
+          <groupId>junit</groupId>
+          <artifactId>junit</artifactId>
+        </exclusion>
+      </exclusions>
+    </dependency>
+    <dependency>
+      <groupId>org.example</groupId>
+      <artifactId>from-parent</artifactId>
+      <version>${undefined.version}</version>
+    </dependency>
+  </dependencies>
+</project>`
+
+func Test_componentsFromPom(t *testing.T) {
+	content := func(string) (string, error) { return testPom, nil }
+
+	tests := []struct {
+		name  string
+		added []int
+		want  map[changeLocation]component
+	}{
+		{
+			"property bumped",
+			[]int{9},
+			map[changeLocation]component{
+				changeLocation{Position: 9, Line: 9}: component{format: "maven", group: "org.springframework", name: "spring-core", version: "5.3.20"},
+			},
+		},
+		{
+			"managed version bumped",
+			[]int{10},
+			map[changeLocation]component{
+				changeLocation{Position: 10, Line: 10}: component{format: "maven", group: "com.fasterxml.jackson.core", name: "jackson-databind", version: "2.13.0"},
+			},
+		},
+		{
+			"dependency without version added",
+			[]int{34, 35, 36, 37},
+			map[changeLocation]component{
+				changeLocation{Position: 36, Line: 36}: component{format: "maven", group: "com.fasterxml.jackson.core", name: "jackson-databind", version: "2.13.0"},
+			},
+		},
+		{
+			"property version used by new dependency",
+			[]int{29, 30, 31, 32, 33},
+			map[changeLocation]component{
+				changeLocation{Position: 32, Line: 32}: component{format: "maven", group: "org.springframework", name: "spring-web", version: "5.3.20"},
+			},
+		},
+		{
+			"project properties",
+			[]int{41},
+			map[changeLocation]component{
+				changeLocation{Position: 41, Line: 41}: component{format: "maven", group: "com.example", name: "app-common", version: "1.0.0"},
+			},
+		},
+		{
+			"literal version with exclusions",
+			[]int{46, 49, 50},
+			map[changeLocation]component{
+				changeLocation{Position: 46, Line: 46}: component{format: "maven", group: "commons-io", name: "commons-io", version: "2.6"},
+			},
+		},
+		{
+			"undefined property",
+			[]int{57},
+			map[changeLocation]component{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := changedFile{Filename: "pom.xml", Patch: patchAddingLines(testPom, tt.added...)}
+			got, err := componentsFromPom(f, manifestSource{content: content})
+			if err != nil {
+				t.Fatalf("componentsFromPom() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error("componentsFromPom()")
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+}
+
+func Test_componentsFromPom_patchOnly(t *testing.T) {
+	patch := `@@ -24,6 +24,11 @@
+     <dependency>
++      <groupId>org.springframework</groupId>
++      <artifactId>spring-beans</artifactId>
++      <version>${spring.version}</version>
++    </dependency>
++    <dependency>
+       <groupId>org.springframework</groupId>
+       <artifactId>spring-core</artifactId>
+-      <version>5.3.1</version>
++      <version>5.3.20</version>
+     </dependency>`
+	want := map[changeLocation]component{
+		changeLocation{Position: 10, Line: 32}: component{format: "maven", group: "org.springframework", name: "spring-core", version: "5.3.20"},
+	}
+
+	got, err := componentsFromPom(changedFile{Filename: "pom.xml", Patch: patch}, manifestSource{})
+	if err != nil {
+		t.Fatalf("componentsFromPom() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("componentsFromPom()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+}
+
+const testPomBuild = `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0">
+  <modelVersion>4.0.0</modelVersion>
+  <parent>
+    <groupId>org.springframework.boot</groupId>
+    <artifactId>spring-boot-starter-parent</artifactId>
+    <version>2.6.1</version>
+  </parent>
+  <artifactId>app</artifactId>
+
+  <properties>
+    <jib.version>3.1.4</jib.version>
+  </properties>
+
+  <dependencyManagement>
+    <dependencies>
+      <dependency>
+        <groupId>org.springframework.cloud</groupId>
+        <artifactId>spring-cloud-dependencies</artifactId>
+        <version>2021.0.0</version>
+        <type>pom</type>
+        <scope>import</scope>
+      </dependency>
+    </dependencies>
+  </dependencyManagement>
+
+  <build>
+    <pluginManagement>
+      <plugins>
+        <plugin>
+          <artifactId>maven-surefire-plugin</artifactId>
+          <version>2.22.2</version>
+        </plugin>
+      </plugins>
+    </pluginManagement>
+    <plugins>
+      <plugin>
+        <groupId>com.google.cloud.tools</groupId>
+        <artifactId>jib-maven-plugin</artifactId>
+        <version>${jib.version}</version>
+        <dependencies>
+          <dependency>
+            <groupId>org.ow2.asm</groupId>
+            <artifactId>asm</artifactId>
+            <version>9.2</version>
+          </dependency>
+        </dependencies>
+      </plugin>
+      <plugin>
+        <artifactId>maven-surefire-plugin</artifactId>
+      </plugin>
+    </plugins>
+  </build>
+</project>`
+
+func Test_componentsFromPom_build(t *testing.T) {
+	content := func(string) (string, error) { return testPomBuild, nil }
+
+	tests := []struct {
+		name  string
+		added []int
+		want  map[changeLocation]component
+	}{
+		{
+			"parent bumped",
+			[]int{7},
+			map[changeLocation]component{
+				changeLocation{Position: 7, Line: 7}: component{format: "maven", group: "org.springframework.boot", name: "spring-boot-starter-parent", version: "2.6.1", kind: "pom"},
+			},
+		},
+		{
+			"imported bom bumped",
+			[]int{20},
+			map[changeLocation]component{
+				changeLocation{Position: 20, Line: 20}: component{format: "maven", group: "org.springframework.cloud", name: "spring-cloud-dependencies", version: "2021.0.0", kind: "pom"},
+			},
+		},
+		{
+			"plugin version property bumped",
+			[]int{12},
+			map[changeLocation]component{
+				changeLocation{Position: 12, Line: 12}: component{format: "maven", group: "com.google.cloud.tools", name: "jib-maven-plugin", version: "3.1.4"},
+			},
+		},
+		{
+			"plugin dependency bumped",
+			[]int{45},
+			map[changeLocation]component{
+				changeLocation{Position: 45, Line: 45}: component{format: "maven", group: "org.ow2.asm", name: "asm", version: "9.2"},
+			},
+		},
+		{
+			"managed plugin bumped",
+			[]int{32},
+			map[changeLocation]component{
+				changeLocation{Position: 32, Line: 32}: component{format: "maven", group: "org.apache.maven.plugins", name: "maven-surefire-plugin", version: "2.22.2"},
+			},
+		},
+		{
+			"plugin without version added",
+			[]int{49, 50, 51},
+			map[changeLocation]component{
+				changeLocation{Position: 50, Line: 50}: component{format: "maven", group: "org.apache.maven.plugins", name: "maven-surefire-plugin", version: "2.22.2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := changedFile{Filename: "pom.xml", Patch: patchAddingLines(testPomBuild, tt.added...)}
+			got, err := componentsFromPom(f, manifestSource{content: content})
+			if err != nil {
+				t.Fatalf("componentsFromPom() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error("componentsFromPom()")
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semver is a version as understood by npm, e.g. 1.2.3-beta.1
+type semver struct {
+	major, minor, patch int64
+	prerelease          string
+}
+
+var reSemver = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)\.([0-9]+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+func parseSemver(s string) (semver, bool) {
+	m := reSemver.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return semver{}, false
+	}
+	major, _ := strconv.ParseInt(m[1], 10, 64)
+	minor, _ := strconv.ParseInt(m[2], 10, 64)
+	patch, _ := strconv.ParseInt(m[3], 10, 64)
+	return semver{major, minor, patch, m[4]}, true
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if v.prerelease != "" {
+		s += "-" + v.prerelease
+	}
+	return s
+}
+
+// compare returns -1, 0 or 1 if the version is lower, the same as or higher than the other one
+func (v semver) compare(o semver) int {
+	cmp := func(a, b int64) int {
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	if c := cmp(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := cmp(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := cmp(v.patch, o.patch); c != 0 {
+		return c
+	}
+
+	// A prerelease is lower than its release
+	switch {
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case o.prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.prerelease, "."), strings.Split(o.prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, aerr := strconv.ParseInt(a[i], 10, 64)
+		bn, berr := strconv.ParseInt(b[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if c := cmp(an, bn); c != 0 {
+				return c
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case a[i] != b[i]:
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return cmp(int64(len(a)), int64(len(b)))
+}
+
+// partialVersion is a version which may be missing parts or have them as wildcards, e.g. 1.x or 1.2
+type partialVersion struct {
+	semver
+	// parts is how many of major, minor and patch were given
+	parts int
+}
+
+var rePartial = regexp.MustCompile(`^v?(?:([0-9]+|[xX*])(?:\.([0-9]+|[xX*])(?:\.([0-9]+|[xX*])(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?)?)?)?$`)
+
+func parsePartialVersion(s string) (partialVersion, bool) {
+	m := rePartial.FindStringSubmatch(s)
+	if m == nil {
+		return partialVersion{}, false
+	}
+
+	var p partialVersion
+	nums := []*int64{&p.major, &p.minor, &p.patch}
+	for i, part := range m[1:4] {
+		if part == "" || part == "x" || part == "X" || part == "*" {
+			break
+		}
+		*nums[i], _ = strconv.ParseInt(part, 10, 64)
+		p.parts++
+	}
+	if p.parts == 3 {
+		p.prerelease = m[4]
+	}
+	return p, true
+}
+
+// next returns the lowest version above every version matched by the partial, e.g. 1.3.0-0 for 1.2
+func (p partialVersion) next() semver {
+	switch p.parts {
+	case 1:
+		return semver{p.major + 1, 0, 0, "0"}
+	case 2:
+		return semver{p.major, p.minor + 1, 0, "0"}
+	}
+	return semver{p.major, p.minor, p.patch + 1, "0"}
+}
+
+type comparator struct {
+	op      string
+	version semver
+}
+
+func (c comparator) matches(v semver) bool {
+	cmp := v.compare(c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// npmRange is a union of comparator sets, each of which must all match, e.g. ">=1.2.3 <2.0.0-0 || 3.0.0"
+type npmRange [][]comparator
+
+var (
+	reRangeOperatorSpace = regexp.MustCompile(`(<=|>=|<|>|=|~>?|\^)\s+`)
+	reRangeHyphen        = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+	reRangePrimitive     = regexp.MustCompile(`^(<=|>=|<|>|=|~>?|\^)?(.*)$`)
+)
+
+// parseNpmRange parses a range as described by https://docs.npmjs.com/cli/v6/using-npm/semver#ranges
+func parseNpmRange(spec string) (npmRange, error) {
+	var r npmRange
+	for _, alternative := range strings.Split(spec, "||") {
+		alternative = reRangeOperatorSpace.ReplaceAllString(strings.TrimSpace(alternative), "$1")
+
+		var set []comparator
+		if m := reRangeHyphen.FindStringSubmatch(alternative); m != nil {
+			from, ok := parsePartialVersion(m[1])
+			if !ok {
+				return nil, fmt.Errorf("invalid version %q in range %q", m[1], spec)
+			}
+			to, ok := parsePartialVersion(m[2])
+			if !ok {
+				return nil, fmt.Errorf("invalid version %q in range %q", m[2], spec)
+			}
+			set = append(set, comparator{">=", from.semver})
+			switch {
+			case to.parts == 3:
+				set = append(set, comparator{"<=", to.semver})
+			case to.parts > 0:
+				set = append(set, comparator{"<", to.next()})
+			}
+			r = append(r, set)
+			continue
+		}
+
+		for _, primitive := range strings.Fields(alternative) {
+			comparators, err := desugarRangePrimitive(primitive)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %v", spec, err)
+			}
+			set = append(set, comparators...)
+		}
+		if len(set) == 0 {
+			set = []comparator{{">=", semver{}}}
+		}
+		r = append(r, set)
+	}
+	return r, nil
+}
+
+// desugarRangePrimitive turns a single operator and partial version into the comparators it stands for
+func desugarRangePrimitive(primitive string) ([]comparator, error) {
+	m := reRangePrimitive.FindStringSubmatch(primitive)
+	op, p := m[1], m[2]
+
+	v, ok := parsePartialVersion(p)
+	if !ok {
+		return nil, fmt.Errorf("invalid version %q", p)
+	}
+
+	if v.parts == 0 {
+		// Wildcards match everything, except when they can't match anything
+		if op == "<" || op == ">" {
+			return []comparator{{"<", semver{0, 0, 0, "0"}}}, nil
+		}
+		return []comparator{{">=", semver{}}}, nil
+	}
+
+	switch op {
+	case "^":
+		upper := partialVersion{v.semver, 1}
+		switch {
+		case v.major == 0 && v.parts == 1:
+		case v.major == 0 && (v.minor != 0 || v.parts == 2):
+			upper.parts = 2
+		case v.major == 0:
+			upper.parts = 3
+		}
+		return []comparator{{">=", v.semver}, {"<", upper.next()}}, nil
+	case "~", "~>":
+		upper := partialVersion{v.semver, 2}
+		if v.parts == 1 {
+			upper.parts = 1
+		}
+		return []comparator{{">=", v.semver}, {"<", upper.next()}}, nil
+	case ">":
+		if v.parts < 3 {
+			return []comparator{{">=", v.next()}}, nil
+		}
+		return []comparator{{">", v.semver}}, nil
+	case ">=":
+		return []comparator{{">=", v.semver}}, nil
+	case "<":
+		if v.parts < 3 {
+			return []comparator{{"<", semver{v.major, v.minor, v.patch, "0"}}}, nil
+		}
+		return []comparator{{"<", v.semver}}, nil
+	case "<=":
+		if v.parts < 3 {
+			return []comparator{{"<", v.next()}}, nil
+		}
+		return []comparator{{"<=", v.semver}}, nil
+	}
+
+	// An exact version, or an x-range if parts are missing
+	if v.parts < 3 {
+		return []comparator{{">=", v.semver}, {"<", v.next()}}, nil
+	}
+	return []comparator{{"=", v.semver}}, nil
+}
+
+func (r npmRange) satisfies(v semver) bool {
+	for _, set := range r {
+		matched := true
+		for _, c := range set {
+			if !c.matches(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// floor returns the lowest version the range allows.
+// Returns false if the range has no lower bound, e.g. "*" or "<2", as there's no concrete version to evaluate.
+func (r npmRange) floor() (semver, bool) {
+	var (
+		lowest semver
+		found  bool
+	)
+	for _, set := range r {
+		var (
+			lower   semver
+			bounded bool
+		)
+		for _, c := range set {
+			candidate := c.version
+			switch c.op {
+			case ">":
+				candidate = semver{c.version.major, c.version.minor, c.version.patch + 1, ""}
+			case "<", "<=":
+				continue
+			}
+			if !bounded || candidate.compare(lower) > 0 {
+				lower = candidate
+			}
+			bounded = true
+		}
+		if !bounded || lower == (semver{}) || !r.satisfies(lower) {
+			continue
+		}
+		if !found || lower.compare(lowest) < 0 {
+			lowest, found = lower, true
+		}
+	}
+	return lowest, found
+}
+
+var reNonRegistrySpec = regexp.MustCompile(`^(git\+|git:|github:|gitlab:|bitbucket:|gist:|file:|link:|workspace:|portal:|patch:|https?:|[^@\s/]+/[^\s]+$)`)
+
+// resolveNpmSpec returns the package and concrete version to evaluate for a dependency specifier,
+// following npm: aliases. Returns false for specifiers which are not for a registry version, e.g. git URLs or tags.
+func resolveNpmSpec(name, spec string) (string, semver, bool) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "npm:") {
+		alias := strings.TrimPrefix(spec, "npm:")
+		name, spec = alias, ""
+		if i := strings.LastIndex(alias, "@"); i > 0 {
+			name, spec = alias[:i], alias[i+1:]
+		}
+	}
+
+	if reNonRegistrySpec.MatchString(spec) {
+		return name, semver{}, false
+	}
+
+	r, err := parseNpmRange(spec)
+	if err != nil {
+		return name, semver{}, false
+	}
+
+	version, ok := r.floor()
+	return name, version, ok
+}
+
+// suggestNpmSpec rewrites a dependency specifier to require the remediated version while keeping its range operator,
+// e.g. ^1.2.3 becomes ^1.4.0. Returns an empty string if the specifier isn't for a registry version.
+func suggestNpmSpec(spec, version string) string {
+	var alias string
+	if strings.HasPrefix(spec, "npm:") {
+		i := strings.LastIndex(spec, "@")
+		if i <= len("npm:") {
+			return ""
+		}
+		alias, spec = spec[:i+1], spec[i+1:]
+	}
+
+	if reNonRegistrySpec.MatchString(spec) {
+		return ""
+	}
+	r, err := parseNpmRange(spec)
+	if err != nil {
+		return ""
+	}
+	v, ok := parseSemver(version)
+	if !ok {
+		return ""
+	}
+
+	// Keep the range as is other than its lower bound if it allows the new version, e.g. ">=1.2.0 <2"
+	if floor, ok := r.floor(); ok && r.satisfies(v) && len(r) == 1 {
+		if strings.Contains(spec, floor.String()) {
+			return alias + strings.Replace(spec, floor.String(), version, 1)
+		}
+	}
+
+	spec = reRangeOperatorSpace.ReplaceAllString(strings.TrimSpace(spec), "$1")
+	op := reRangePrimitive.FindStringSubmatch(spec)[1]
+	switch op {
+	case "<", "<=":
+		op = ">="
+	case "=":
+		op = ""
+	case "":
+		// npm's default range for anything which wasn't an exact version, e.g. 1.x
+		if _, exact := parseSemver(spec); !exact {
+			op = "^"
+		}
+	}
+	return alias + op + version
+}
@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+func Test_npmRange(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantFloor string
+		satisfies []string
+		rejects   []string
+	}{
+		{"1.2.3", "1.2.3", []string{"1.2.3"}, []string{"1.2.4", "1.2.3-beta"}},
+		{"=v1.2.3", "1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{"^1.2.3", "1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2", "2.0.0-0"}},
+		{"^0.2.3", "0.2.3", []string{"0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", "0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"^1.x", "1.0.0", []string{"1.9.9"}, []string{"2.0.0"}},
+		{"~1.2.3", "1.2.3", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"~1", "1.0.0", []string{"1.9.0"}, []string{"2.0.0"}},
+		{"1.x", "1.0.0", []string{"1.0.0", "1.99.0"}, []string{"2.0.0", "0.9.0"}},
+		{"1.2.*", "1.2.0", []string{"1.2.7"}, []string{"1.3.0"}},
+		{">=1.2.0 <2", "1.2.0", []string{"1.2.0", "1.9.9"}, []string{"2.0.0", "1.1.9"}},
+		{">= 1.2.0", "1.2.0", []string{"5.0.0"}, []string{"1.1.0"}},
+		{">1.2.3", "1.2.4", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"1.2.3 - 2.3", "1.2.3", []string{"2.3.9"}, []string{"2.4.0"}},
+		{"^2.0.0 || ^1.5.0", "1.5.0", []string{"1.6.0", "2.1.0"}, []string{"1.4.0", "3.0.0"}},
+		{"*", "", []string{"1.0.0"}, nil},
+		{"", "", []string{"1.0.0"}, nil},
+		{"<2", "", []string{"1.0.0"}, []string{"2.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			r, err := parseNpmRange(tt.spec)
+			if err != nil {
+				t.Fatalf("parseNpmRange() error = %v", err)
+			}
+
+			floor, ok := r.floor()
+			if got := floor.String(); ok != (tt.wantFloor != "") || (ok && got != tt.wantFloor) {
+				t.Errorf("floor() = %s, %v, want %q", got, ok, tt.wantFloor)
+			}
+
+			for _, s := range tt.satisfies {
+				v, _ := parseSemver(s)
+				if !r.satisfies(v) {
+					t.Errorf("satisfies(%s) = false, want true", s)
+				}
+			}
+			for _, s := range tt.rejects {
+				v, _ := parseSemver(s)
+				if r.satisfies(v) {
+					t.Errorf("satisfies(%s) = true, want false", s)
+				}
+			}
+		})
+	}
+}
+
+func Test_resolveNpmSpec(t *testing.T) {
+	tests := []struct {
+		name, spec  string
+		wantName    string
+		wantVersion string
+		wantOK      bool
+	}{
+		{"moment", "^2.1.0", "moment", "2.1.0", true},
+		{"lodash", "4.x", "lodash", "4.0.0", true},
+		{"legacy", "npm:lodash@^4.17.0", "lodash", "4.17.0", true},
+		{"scoped", "npm:@babel/core@7.12.0", "@babel/core", "7.12.0", true},
+		{"git", "git+https://github.com/user/repo.git", "git", "", false},
+		{"github", "user/repo#v1.0.0", "github", "", false},
+		{"file", "file:../lib", "file", "", false},
+		{"workspace", "workspace:^1.0.0", "workspace", "", false},
+		{"tarball", "https://example.com/pkg.tgz", "tarball", "", false},
+		{"tag", "latest", "tag", "", false},
+		{"any", "*", "any", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, version, ok := resolveNpmSpec(tt.name, tt.spec)
+			if ok != tt.wantOK || (ok && (name != tt.wantName || version.String() != tt.wantVersion)) {
+				t.Errorf("resolveNpmSpec() = %s, %s, %v, want %s, %s, %v", name, version, ok, tt.wantName, tt.wantVersion, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_suggestNpmSpec(t *testing.T) {
+	tests := []struct {
+		spec, version, want string
+	}{
+		{"^1.2.3", "1.4.0", "^1.4.0"},
+		{"^1.2.3", "2.0.1", "^2.0.1"},
+		{"~1.2.3", "1.2.9", "~1.2.9"},
+		{"1.2.3", "1.2.9", "1.2.9"},
+		{"=1.2.3", "1.2.9", "1.2.9"},
+		{">=1.2.0 <2", "1.4.0", ">=1.4.0 <2"},
+		{">=1.2.0 <2", "2.5.0", ">=2.5.0"},
+		{"1.x", "1.4.0", "^1.4.0"},
+		{"npm:lodash@^4.17.0", "4.17.21", "npm:lodash@^4.17.21"},
+		{"git+https://github.com/user/repo.git", "1.0.0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := suggestNpmSpec(tt.spec, tt.version); got != tt.want {
+				t.Errorf("suggestNpmSpec(%q, %q) = %q, want %q", tt.spec, tt.version, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	reXMLAttribute  = regexp.MustCompile(`\b([A-Za-z]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	reNugetVersion  = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	reMSBuildProp   = regexp.MustCompile(`\$\(([^)]+)\)`)
+	reNugetElement  = regexp.MustCompile(`<(PackageReference|PackageVersion|GlobalPackageReference)\b[^>]*>`)
+	reNugetPackage  = regexp.MustCompile(`<package\b[^>]*>`)
+	rePaketNuget    = regexp.MustCompile(`^\s*nuget\s+(\S+)(.*)$`)
+	rePaketLocked   = regexp.MustCompile(`^    ([^\s(]+) \(([^)\s]+)\)`)
+	rePaketOperator = regexp.MustCompile(`(>=|<=|==|~>|=|>|<)\s*`)
+	reNugetRange    = regexp.MustCompile(`([\[(])([^\[\]()]*)([\])])`)
+)
+
+// xmlAttributes returns the attributes of an XML tag found in a line, keyed by their lowercased name
+func xmlAttributes(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range reXMLAttribute.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = m[2] + m[3]
+	}
+	return attrs
+}
+
+// nugetVersionFloor returns the lowest version a NuGet version range allows, as described by
+// https://docs.microsoft.com/en-us/nuget/concepts/package-versioning#version-ranges.
+// A bare version is a minimum, e.g. 1.0 is 1.0 or higher. Returns false for ranges without an inclusive lower bound and floating versions.
+func nugetVersionFloor(spec string) (string, bool) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(spec, "["):
+		spec = strings.TrimSpace(strings.SplitN(strings.Trim(spec, "[]()"), ",", 2)[0])
+	case strings.HasPrefix(spec, "("):
+		return "", false
+	}
+	return spec, reNugetVersion.MatchString(spec)
+}
+
+// nugetLineAllows checks whether the version ranges in a line of a project, packages.config or paket.dependencies allow the version,
+// e.g. not 3.0 for [3.0,2.0) or paket's >= 3.0 < 2. A bare version is a minimum, which always allows the version it was set to.
+func nugetLineAllows(line, version string) bool {
+	if m := rePaketNuget.FindStringSubmatch(line); m != nil {
+		return paketConstraintAllows(m[2], version)
+	}
+
+	for _, m := range reNugetRange.FindAllStringSubmatch(line, -1) {
+		opening, bounds, closing := m[1], strings.Split(m[2], ","), m[3]
+		lower, upper := strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[len(bounds)-1])
+		// Anything else in brackets, such as $(Property), isn't a range
+		if len(bounds) > 2 || (lower != "" && !reNugetVersion.MatchString(lower)) || (upper != "" && !reNugetVersion.MatchString(upper)) {
+			continue
+		}
+		if len(bounds) == 1 && (opening != "[" || closing != "]") {
+			continue
+		}
+
+		if lower != "" {
+			cmp, ok := compareReleaseVersions(version, lower)
+			if !ok || cmp < 0 || (cmp == 0 && opening == "(") {
+				return false
+			}
+		}
+		if upper != "" {
+			cmp, ok := compareReleaseVersions(version, upper)
+			if !ok || cmp > 0 || (cmp == 0 && closing == ")") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// componentsFromNuget finds the packages of a packages.config, whose versions are always exact
+func componentsFromNuget(lines map[changeLocation]string) (map[changeLocation]component, error) {
+	components := make(map[changeLocation]component)
+	for loc, line := range lines {
+		tag := reNugetPackage.FindString(line)
+		if tag == "" {
+			continue
+		}
+		attrs := xmlAttributes(tag)
+		if attrs["id"] == "" || !reNugetVersion.MatchString(attrs["version"]) {
+			continue
+		}
+		components[loc] = component{format: "nuget", name: attrs["id"], version: attrs["version"]}
+	}
+	return components, nil
+}
+
+// nugetReference is a package referenced by an MSBuild project, or whose version is set centrally by Directory.Packages.props
+type nugetReference struct {
+	name, version lineValue
+	line          int64
+}
+
+// msbuildProject is what is needed of an MSBuild project to know the versions of the packages it references
+type msbuildProject struct {
+	properties map[string]lineValue
+	references []nugetReference
+}
+
+// parseMSBuildProject reads the properties and package references of a project, keeping track of the line each value is on
+func parseMSBuildProject(content string) (msbuildProject, error) {
+	lineAt := lineIndex(content)
+	project := msbuildProject{properties: make(map[string]lineValue)}
+
+	var (
+		stack []string
+		text  strings.Builder
+		line  int64
+		ref   *nugetReference
+	)
+	dec := xml.NewDecoder(strings.NewReader(content))
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return msbuildProject{}, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+			line = lineAt(start)
+			if isNugetElement(t.Name.Local) {
+				ref = &nugetReference{line: line}
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "Include", "Update":
+						ref.name = lineValue{a.Value, line}
+					case "Version", "VersionOverride":
+						ref.version = lineValue{a.Value, line}
+					}
+				}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := lineValue{strings.TrimSpace(text.String()), line}
+			switch {
+			case ref != nil && (t.Name.Local == "Version" || t.Name.Local == "VersionOverride"):
+				ref.version = value
+			case ref != nil && isNugetElement(t.Name.Local):
+				project.references = append(project.references, *ref)
+				ref = nil
+			case len(stack) > 1 && stack[len(stack)-2] == "PropertyGroup":
+				project.properties[t.Name.Local] = value
+			}
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+	return project, nil
+}
+
+// isNugetElement checks for the MSBuild items which reference a package or set its version
+func isNugetElement(name string) bool {
+	return name == "PackageReference" || name == "PackageVersion" || name == "GlobalPackageReference"
+}
+
+// resolve replaces the MSBuild properties in a value, returning the lines of the properties it used.
+// Returns false if a property isn't defined in the project, e.g. because it comes from an imported file.
+func (p msbuildProject) resolve(value string) (string, []int64, bool) {
+	return interpolate(value, reMSBuildProp, func(name string) (lineValue, bool) {
+		prop, ok := p.properties[name]
+		return prop, ok
+	})
+}
+
+// changedComponents returns the packages whose version was changed by the added lines.
+// Each is reported on the line which changed it: its version, a property its version uses, or the reference itself if it is new.
+func (p msbuildProject) changedComponents(added map[int64]changeLocation) map[changeLocation]component {
+	components := make(map[changeLocation]component)
+	for _, ref := range p.references {
+		// References without a version get it from Directory.Packages.props, which is reviewed on its own
+		if ref.name.text == "" || ref.version.text == "" {
+			continue
+		}
+		name, _, ok := p.resolve(ref.name.text)
+		if !ok {
+			continue
+		}
+		spec, propertyLines, ok := p.resolve(ref.version.text)
+		if !ok {
+			continue
+		}
+		version, ok := nugetVersionFloor(spec)
+		if !ok {
+			continue
+		}
+
+		candidates := append([]int64{ref.version.line}, propertyLines...)
+		candidates = append(candidates, ref.line)
+		for _, line := range candidates {
+			loc, ok := added[line]
+			if !ok {
+				continue
+			}
+			if _, taken := components[loc]; !taken {
+				components[loc] = component{format: "nuget", name: name, version: version}
+			}
+			break
+		}
+	}
+	return components
+}
+
+// componentsFromMSBuildProject finds the packages changed in a project file or Directory.Packages.props.
+// The full file is needed to resolve properties and versions on their own lines, so without it only literal versions on added lines are found.
+func componentsFromMSBuildProject(f changedFile, src manifestSource) (map[changeLocation]component, error) {
+	if src.content == nil {
+		return msbuildComponentsFromPatch(f.Patch), nil
+	}
+
+	content, err := src.content(f.Filename)
+	if err != nil {
+		log.Printf("WARN: could not get content of %s, only reviewing literal versions in the diff: %v\n", f.Filename, err)
+		return msbuildComponentsFromPatch(f.Patch), nil
+	}
+
+	project, err := parseMSBuildProject(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse project: %v", err)
+	}
+
+	added := make(map[int64]changeLocation)
+	for loc := range parsePatchLineAdditions(f.Patch) {
+		added[loc.Line] = loc
+	}
+	return project.changedComponents(added), nil
+}
+
+// msbuildComponentsFromPatch finds the package references added by the patch which have a literal version on the same line
+func msbuildComponentsFromPatch(patch string) map[changeLocation]component {
+	components := make(map[changeLocation]component)
+	for loc, line := range parsePatchLineAdditions(patch) {
+		tag := reNugetElement.FindString(line)
+		if tag == "" {
+			continue
+		}
+		attrs := xmlAttributes(tag)
+		name, spec := attrs["include"]+attrs["update"], attrs["version"]+attrs["versionoverride"]
+		if name == "" {
+			continue
+		}
+		if version, ok := nugetVersionFloor(spec); ok {
+			components[loc] = component{format: "nuget", name: name, version: version}
+		}
+	}
+	return components
+}
+
+// componentsFromNugetLockfile finds the resolved packages of a packages.lock.json.
+// Packages are listed for each target framework, so only the first added line of each is kept.
+func componentsFromNugetLockfile(lines []patchLine) map[int64]component {
+	found := make(map[int64]component)
+	seen := make(map[component]bool)
+
+	var name string
+	for _, l := range lines {
+		key, value, ok := jsonKeyValue(l.text)
+		if !ok {
+			continue
+		}
+		if value == "{" {
+			name = key
+			continue
+		}
+		if key != "resolved" || name == "" || !l.added {
+			continue
+		}
+		c := component{format: "nuget", name: name, version: value}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		found[l.location.Line] = c
+	}
+	return found
+}
+
+var reJSONKeyValue = regexp.MustCompile(`^\s*"([^"]+)"\s*:\s*(?:"([^"]*)"|(\{))`)
+
+// jsonKeyValue returns the key of a line of formatted JSON and its value if it is a string, or "{" if it opens an object
+func jsonKeyValue(line string) (string, string, bool) {
+	m := reJSONKeyValue.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2] + m[3], true
+}
+
+// paketVersionFloor returns the lowest version a paket version constraint allows, e.g. 1.2 for ~> 1.2 or >= 1.2 < 2.
+// A bare version is a minimum. Returns false for constraints without an inclusive lower bound.
+// See https://fsprojects.github.io/Paket/nuget-dependencies.html#Version-constraints
+func paketVersionFloor(constraint string) (string, bool) {
+	fields := strings.Fields(rePaketOperator.ReplaceAllString(constraint, "$1"))
+
+	var floor string
+	for _, f := range fields {
+		if strings.Contains(f, ":") {
+			// Options such as restriction: or framework: end the constraint
+			break
+		}
+		op := rePaketOperator.FindString(f)
+		version := strings.TrimPrefix(f, op)
+		if !reNugetVersion.MatchString(version) {
+			continue
+		}
+		switch op {
+		case "=", "==":
+			return version, true
+		case "", ">=", "~>":
+			if floor == "" {
+				floor = version
+			}
+		}
+	}
+	return floor, floor != ""
+}
+
+// paketConstraintAllows checks whether every clause of a paket version constraint allows the version.
+// A ~> clause allows up to the next version of its second to last segment, e.g. ~> 1.2 is >= 1.2 < 2 and ~> 1.2.3 is >= 1.2.3 < 1.3.
+func paketConstraintAllows(constraint, version string) bool {
+	for _, f := range strings.Fields(rePaketOperator.ReplaceAllString(constraint, "$1")) {
+		if strings.Contains(f, ":") {
+			break
+		}
+		op := rePaketOperator.FindString(f)
+		clause := strings.TrimPrefix(f, op)
+		if !reNugetVersion.MatchString(clause) {
+			continue
+		}
+
+		cmp, ok := compareReleaseVersions(version, clause)
+		if !ok {
+			return false
+		}
+		var allowed bool
+		switch op {
+		case "", ">=":
+			allowed = cmp >= 0
+		case "=", "==":
+			allowed = cmp == 0
+		case ">":
+			allowed = cmp > 0
+		case "<":
+			allowed = cmp < 0
+		case "<=":
+			allowed = cmp <= 0
+		case "~>":
+			segments := strings.Split(clause, ".")
+			if len(segments) > 1 {
+				segments = segments[:len(segments)-1]
+			}
+			next, err := strconv.Atoi(segments[len(segments)-1])
+			if err != nil {
+				return false
+			}
+			segments[len(segments)-1] = strconv.Itoa(next + 1)
+			below, ok := compareReleaseVersions(version, strings.Join(segments, "."))
+			allowed = cmp >= 0 && ok && below < 0
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// componentsFromPaketDependencies finds the NuGet packages of a paket.dependencies, evaluated at the lowest version their constraint allows
+func componentsFromPaketDependencies(lines map[changeLocation]string) (map[changeLocation]component, error) {
+	components := make(map[changeLocation]component)
+	for loc, line := range lines {
+		m := rePaketNuget.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if version, ok := paketVersionFloor(m[2]); ok {
+			components[loc] = component{format: "nuget", name: m[1], version: version}
+		}
+	}
+	return components, nil
+}
+
+// componentsFromPaketLock finds the resolved packages of the NUGET sections of a paket.lock.
+// Packages are the entries directly under a remote, while the more indented entries are their dependencies' constraints.
+func componentsFromPaketLock(lines []patchLine) map[int64]component {
+	found := make(map[int64]component)
+
+	// Without the whole file, lines before the first section header are assumed to be in a NUGET section
+	section := "NUGET"
+	for _, l := range lines {
+		if l.text != "" && l.text[0] != ' ' {
+			section = strings.TrimSpace(l.text)
+			continue
+		}
+		if section != "NUGET" {
+			continue
+		}
+		if m := rePaketLocked.FindStringSubmatch(l.text); m != nil && reNugetVersion.MatchString(m[2]) {
+			found[l.location.Line] = component{format: "nuget", name: m[1], version: m[2]}
+		}
+	}
+	return found
+}
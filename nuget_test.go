@@ -0,0 +1,259 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testCsproj = `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <TargetFramework>net6.0</TargetFramework>
+    <SerilogVersion>2.10.0</SerilogVersion>
+  </PropertyGroup>
+
+  <ItemGroup>
+    <PackageReference Include="Newtonsoft.Json" Version="13.0.1" />
+    <PackageReference Include="Serilog" Version="$(SerilogVersion)" />
+    <PackageReference Include="Serilog.Sinks.Console" Version="[4.0.0, 5.0.0)" />
+    <PackageReference Include="Dapper">
+      <Version>2.0.123</Version>
+    </PackageReference>
+    <PackageReference Include="Polly" Version="7.*" />
+    <PackageReference Include="AutoMapper" />
+    <PackageReference Include="MediatR" VersionOverride="9.0.0" />
+    <PackageReference Include="Undefined" Version="$(UndefinedVersion)" />
+  </ItemGroup>
+</Project>`
+
+const testDirectoryPackagesProps = `<Project>
+  <PropertyGroup>
+    <ManagePackageVersionsCentrally>true</ManagePackageVersionsCentrally>
+  </PropertyGroup>
+  <ItemGroup>
+    <PackageVersion Include="AutoMapper" Version="10.1.1" />
+    <PackageVersion Include="Microsoft.Extensions.Logging" Version="6.0.0" />
+  </ItemGroup>
+</Project>`
+
+func Test_componentsFromMSBuildProject(t *testing.T) {
+	nuget := func(name, version string) component {
+		return component{format: "nuget", name: name, version: version}
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		added    []int
+		want     map[int64]component
+	}{
+		{
+			"literal version",
+			"src/App/App.csproj", testCsproj, []int{8},
+			map[int64]component{8: nuget("Newtonsoft.Json", "13.0.1")},
+		},
+		{
+			"property bumped",
+			"src/App/App.fsproj", testCsproj, []int{4},
+			map[int64]component{4: nuget("Serilog", "2.10.0")},
+		},
+		{
+			"range and version element",
+			"App.vbproj", testCsproj, []int{10, 11, 12, 13},
+			map[int64]component{
+				10: nuget("Serilog.Sinks.Console", "4.0.0"),
+				12: nuget("Dapper", "2.0.123"),
+			},
+		},
+		{
+			"floating, central, overridden and undefined versions",
+			"App.csproj", testCsproj, []int{14, 15, 16, 17},
+			map[int64]component{16: nuget("MediatR", "9.0.0")},
+		},
+		{
+			"central package versions",
+			"Directory.Packages.props", testDirectoryPackagesProps, []int{6, 7},
+			map[int64]component{
+				6: nuget("AutoMapper", "10.1.1"),
+				7: nuget("Microsoft.Extensions.Logging", "6.0.0"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := func(string) (string, error) { return tt.content, nil }
+			checkAddedComponents(t, tt.filename, tt.content, tt.added, manifestSource{content: content}, tt.want)
+		})
+	}
+}
+
+func Test_componentsFromMSBuildProject_patchOnly(t *testing.T) {
+	patch := `@@ -6,4 +6,5 @@
+   <ItemGroup>
+-    <PackageReference Include="Newtonsoft.Json" Version="12.0.3" />
++    <PackageReference Include="Newtonsoft.Json" Version="13.0.1" />
++    <PackageReference Include="Serilog" Version="$(SerilogVersion)" />
++    <PackageReference Update="Dapper" Version="[2.0.123]" />
+   </ItemGroup>`
+	want := map[changeLocation]component{
+		changeLocation{Position: 3, Line: 7}: component{format: "nuget", name: "Newtonsoft.Json", version: "13.0.1"},
+		changeLocation{Position: 5, Line: 9}: component{format: "nuget", name: "Dapper", version: "2.0.123"},
+	}
+
+	got, err := componentsFromMSBuildProject(changedFile{Filename: "App.csproj", Patch: patch}, manifestSource{})
+	if err != nil {
+		t.Fatalf("componentsFromMSBuildProject() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("componentsFromMSBuildProject()")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+}
+
+func Test_nugetLockfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		patch    string
+		want     map[changeLocation]component
+	}{
+		{
+			"packages.lock.json",
+			"packages.lock.json",
+			`@@ -1,20 +1,20 @@
+ {
+   "version": 1,
+   "dependencies": {
+     "net6.0": {
+       "Newtonsoft.Json": {
+         "type": "Direct",
+-        "requested": "[12.0.3, )",
+-        "resolved": "12.0.3",
++        "requested": "[13.0.1, )",
++        "resolved": "13.0.1",
+         "contentHash": "ppPFpBcvxdsfUonNcvITKqLl3bqxWbDCZIzDWHzjpdAHRFfZe0Dw9HmA0+za13IdyrgJwpkDTDA9fHaxOrt20A=="
+       },
+       "Serilog": {
+         "type": "Direct",
+         "requested": "[2.10.0, )",
+         "resolved": "2.10.0",
+         "contentHash": "+QX0hmf37a0/OZLxM3wL7V6/ADvC1XihXN4Kq/p6d8lCPfgkRdiuhbWlMaFjR9Av0dy5F0+MBeDmDdRZN/YwQA=="
+       }
+     },
+     "net48": {
+       "Newtonsoft.Json": {
+         "type": "Direct",
+-        "resolved": "12.0.3",
++        "resolved": "13.0.1",`,
+			map[changeLocation]component{
+				changeLocation{Position: 10, Line: 8}: component{format: "nuget", name: "Newtonsoft.Json", version: "13.0.1"},
+			},
+		},
+		{
+			"paket.dependencies",
+			"paket.dependencies",
+			`@@ -1,5 +1,9 @@
+ source https://api.nuget.org/v3/index.json
+ 
++nuget Newtonsoft.Json 13.0.1
++nuget Serilog ~> 2.10
++nuget Dapper >= 2.0.123 < 3.0 restriction: >= net6.0
++nuget FSharp.Core = 6.0.1
++nuget Argu
++nuget Polly > 7.0
+ github fsharp/FAKE src/app/FakeLib/Globbing/Globbing.fs`,
+			map[changeLocation]component{
+				changeLocation{Position: 3, Line: 3}: component{format: "nuget", name: "Newtonsoft.Json", version: "13.0.1"},
+				changeLocation{Position: 4, Line: 4}: component{format: "nuget", name: "Serilog", version: "2.10"},
+				changeLocation{Position: 5, Line: 5}: component{format: "nuget", name: "Dapper", version: "2.0.123"},
+				changeLocation{Position: 6, Line: 6}: component{format: "nuget", name: "FSharp.Core", version: "6.0.1"},
+			},
+		},
+		{
+			"paket.lock",
+			"paket.lock",
+			`@@ -1,8 +1,8 @@
+ NUGET
+   remote: https://api.nuget.org/v3/index.json
+-    Newtonsoft.Json (12.0.3)
++    Newtonsoft.Json (13.0.1)
+     Serilog (2.10)
+-      Microsoft.CSharp (>= 4.0.1)
++      Microsoft.CSharp (4.7.0)
+ GITHUB
+   remote: fsharp/FAKE
+-    src/app/FakeLib/Globbing/Globbing.fs (0341a2e614eb2a7f34607cec914eb0ed83ce9add)
++    src/app/FakeLib/Globbing/Globbing.fs (1234567)`,
+			map[changeLocation]component{
+				changeLocation{Position: 4, Line: 3}: component{format: "nuget", name: "Newtonsoft.Json", version: "13.0.1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := manifestParserFor(tt.filename)(changedFile{Filename: tt.filename, Patch: tt.patch}, manifestSource{})
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error("parse()")
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+}
+
+func Test_nugetVersionFloor(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		ok   bool
+	}{
+		{"1.0.0", "1.0.0", true},
+		{"[1.0.0]", "1.0.0", true},
+		{"[1.0,2.0)", "1.0", true},
+		{"[ 1.0 , )", "1.0", true},
+		{"(1.0,)", "", false},
+		{"(,1.0]", "", false},
+		{"1.*", "", false},
+		{"6.0.0-preview.1", "6.0.0-preview.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, ok := nugetVersionFloor(tt.spec)
+			if got != tt.want && tt.ok || ok != tt.ok {
+				t.Errorf("nugetVersionFloor(%q) = %q, %v, want %q, %v", tt.spec, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func Test_suggestNugetLine(t *testing.T) {
+	tests := []struct {
+		name                       string
+		line, original, remediated string
+		want                       string
+	}{
+		{"bare minimum", `<PackageReference Include="Foo" Version="1.0" />`, "1.0", "3.0", `<PackageReference Include="Foo" Version="3.0" />`},
+		{"exact", `<PackageReference Include="Foo" Version="[1.0]" />`, "1.0", "3.0", `<PackageReference Include="Foo" Version="[3.0]" />`},
+		{"range still allows the version", `<PackageVersion Include="Foo" Version="[1.0,4.0)" />`, "1.0", "3.0", `<PackageVersion Include="Foo" Version="[3.0,4.0)" />`},
+		{"upper bound below the version", `<PackageReference Include="Foo" Version="[1.0,2.0)" />`, "1.0", "3.0", ""},
+		{"exclusive upper bound at the version", `<Version>[1.0,3.0)</Version>`, "1.0", "3.0", ""},
+		{"property", `<FooVersion>[ 1.0, 2.0 ]</FooVersion>`, "1.0", "3.0", ""},
+		{"condition is not a range", `<PackageReference Include="Foo" Version="1.0" Condition="'$(TargetFramework)' == 'net6.0'" />`, "1.0", "3.0", `<PackageReference Include="Foo" Version="3.0" Condition="'$(TargetFramework)' == 'net6.0'" />`},
+		{"packages.config allowed versions", `<package id="Foo" version="1.0" allowedVersions="[1,2)" />`, "1.0", "3.0", ""},
+		{"paket minimum", `nuget Foo >= 1.2`, "1.2", "2.5", `nuget Foo >= 2.5`},
+		{"paket upper bound below the version", `nuget Foo >= 1.2 < 2`, "1.2", "2.5", ""},
+		{"paket twiddle wakka", `nuget Foo ~> 1.2`, "1.2", "1.5", `nuget Foo ~> 1.5`},
+		{"paket twiddle wakka with an upper bound", `nuget Foo ~> 1.2 < 1.4 restriction: >= net6.0`, "1.2", "1.5", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestAllowedLine(tt.line, tt.original, tt.remediated, nugetLineAllows); got != tt.want {
+				t.Errorf("suggestAllowedLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pep508Requirement is a dependency specification as described by https://peps.python.org/pep-0508/,
+// e.g. requests[security]>=2.8.1,==2.8.* ; python_version < "2.7"
+type pep508Requirement struct {
+	name      string
+	extras    []string
+	specifier string
+	url       string
+	markers   string
+}
+
+var (
+	rePep508Name   = regexp.MustCompile(`^([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?)\s*`)
+	rePep508Extras = regexp.MustCompile(`^\[([^\]]*)\]\s*`)
+)
+
+// parsePep508 parses a requirement, returning false if it is not one, e.g. a pip option or a local path
+func parsePep508(s string) (pep508Requirement, bool) {
+	var r pep508Requirement
+
+	s = strings.TrimSpace(s)
+	m := rePep508Name.FindStringSubmatch(s)
+	if m == nil {
+		return r, false
+	}
+	r.name, s = m[1], s[len(m[0]):]
+
+	if m := rePep508Extras.FindStringSubmatch(s); m != nil {
+		for _, e := range strings.Split(m[1], ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				r.extras = append(r.extras, e)
+			}
+		}
+		s = s[len(m[0]):]
+	}
+
+	if strings.HasPrefix(s, "@") {
+		// The URL is separated from any markers by whitespace
+		s = strings.TrimSpace(s[1:])
+		if i := strings.Index(s, " ;"); i >= 0 {
+			r.url, r.markers = s[:i], strings.TrimSpace(s[i+2:])
+		} else {
+			r.url = s
+		}
+		return r, r.url != ""
+	}
+
+	if i := strings.Index(s, ";"); i >= 0 {
+		s, r.markers = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = s[1 : len(s)-1]
+	}
+	r.specifier = strings.TrimSpace(s)
+
+	if r.specifier != "" && !rePep440Specifier.MatchString(r.specifier) {
+		return r, false
+	}
+	return r, true
+}
+
+var (
+	rePep440Clause    = `\s*(===|==|!=|~=|<=|>=|<|>)\s*([A-Za-z0-9_.*+!-]+)\s*`
+	rePep440Specifier = regexp.MustCompile(`^` + rePep440Clause + `(,` + rePep440Clause + `)*$`)
+	rePep440Clauses   = regexp.MustCompile(rePep440Clause)
+)
+
+// pep440Floor returns the version to evaluate for a version specifier, e.g. 2.8.1 for ">=2.8.1,<3".
+// Like npm ranges, specifiers are evaluated at their lowest allowed version, so only those with an inclusive
+// lower bound which isn't a wildcard resolve to one.
+func pep440Floor(specifier string) (string, bool) {
+	if !rePep440Specifier.MatchString(specifier) {
+		return "", false
+	}
+
+	var floor string
+	excluded := make(map[string]bool)
+	for _, m := range rePep440Clauses.FindAllStringSubmatch(specifier, -1) {
+		op, version := m[1], m[2]
+		switch op {
+		case "===", "==", "~=", ">=":
+			if strings.Contains(version, "*") {
+				return "", false
+			}
+			if op == "==" || op == "===" || floor == "" {
+				floor = version
+			}
+		case "!=":
+			excluded[version] = true
+		}
+	}
+	if floor == "" || excluded[floor] {
+		return "", false
+	}
+	return floor, true
+}
+
+// pypiLineAllows checks whether every version specifier clause in a line allows the version, e.g. not 2.3.3 for flask>=2.3.3,<2.1.
+// Versions which can't be compared aren't allowed, so they are never suggested.
+func pypiLineAllows(line, version string) bool {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	for _, m := range rePep440Clauses.FindAllStringSubmatch(line, -1) {
+		op, clause := m[1], m[2]
+		if op == "===" {
+			if version != clause {
+				return false
+			}
+			continue
+		}
+		if strings.HasSuffix(clause, ".*") && (op == "==" || op == "!=") {
+			prefix := strings.TrimSuffix(clause, "*")
+			if strings.HasPrefix(version+".", prefix) != (op == "==") {
+				return false
+			}
+			continue
+		}
+
+		cmp, ok := compareReleaseVersions(version, clause)
+		if !ok {
+			return false
+		}
+		var allowed bool
+		switch op {
+		case "==":
+			allowed = cmp == 0
+		case "!=":
+			allowed = cmp != 0
+		case ">=":
+			allowed = cmp >= 0
+		case "<=":
+			allowed = cmp <= 0
+		case ">":
+			allowed = cmp > 0
+		case "<":
+			allowed = cmp < 0
+		case "~=":
+			// A compatible release also matches the clause without its last segment, e.g. 2.3.3 for ~=2.2 is 2.*
+			i := strings.LastIndex(clause, ".")
+			allowed = cmp >= 0 && i > 0 && strings.HasPrefix(version+".", clause[:i+1])
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+var rePep503Separators = regexp.MustCompile(`[-_.]+`)
+
+// normalizePypiName normalizes a project name as described by https://peps.python.org/pep-0503/#normalized-names
+func normalizePypiName(name string) string {
+	return strings.ToLower(rePep503Separators.ReplaceAllString(name, "-"))
+}
+
+// pypiRequirement creates the component to evaluate for a requirement, e.g. flask[async]>=2.0 ; python_version >= "3.8".
+// Lines of a requirements file may also have options, comments or a continuation, e.g. flask==2.0 --hash=sha256:abc \
+func pypiRequirement(requirement string) (component, bool) {
+	// Options apply to the whole file, or are hashes continuing the previous line's requirement
+	requirement = strings.TrimSpace(requirement)
+	if strings.HasPrefix(requirement, "-") {
+		return component{}, false
+	}
+
+	// Comments start with a # at the start of the line or after whitespace
+	if i := strings.Index(requirement, " #"); i >= 0 {
+		requirement = requirement[:i]
+	}
+	requirement = strings.TrimSuffix(strings.TrimSpace(requirement), "\\")
+	if i := strings.Index(requirement, " --"); i >= 0 {
+		requirement = requirement[:i]
+	}
+
+	r, ok := parsePep508(requirement)
+	if !ok || r.url != "" {
+		return component{}, false
+	}
+	version, ok := pep440Floor(r.specifier)
+	if !ok {
+		return component{}, false
+	}
+	return component{format: "pypi", name: normalizePypiName(r.name), version: version}, true
+}
+
+func componentsFromPypi(lines map[changeLocation]string) (map[changeLocation]component, error) {
+	components := make(map[changeLocation]component)
+	for loc, l := range lines {
+		if c, ok := pypiRequirement(l); ok {
+			components[loc] = c
+		}
+	}
+	return components, nil
+}
+
+// pypiSpecVersion returns the version to evaluate for a version specifier such as Pipfile's "==1.2.3" or ">=1.2"
+func pypiSpecVersion(spec string) (string, bool) {
+	return pep440Floor(strings.TrimSpace(spec))
+}
+
+var (
+	rePoetryConstraint = regexp.MustCompile(`^(\^|~=|~|==|>=|=)?([0-9][A-Za-z0-9_.+!-]*)$`)
+	rePoetryOperator   = regexp.MustCompile(`([<>=!~^]+)\s+`)
+)
+
+// poetrySpecVersion returns the version to evaluate for a Poetry version constraint.
+// A bare version is exact and caret and tilde constraints are evaluated at their lowest version, e.g. 1.2 for ^1.2.
+func poetrySpecVersion(spec string) (string, bool) {
+	spec = strings.TrimSpace(spec)
+	if strings.Contains(spec, "||") {
+		return "", false
+	}
+
+	// Clauses are separated by commas or spaces, e.g. ">= 1.2, < 1.5" or ">=1.2 <1.5"
+	spec = rePoetryOperator.ReplaceAllString(spec, "$1")
+	var floor string
+	for _, clause := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if m := rePoetryConstraint.FindStringSubmatch(clause); m != nil {
+			if floor == "" || m[1] == "" || m[1] == "==" || m[1] == "=" {
+				floor = m[2]
+			}
+			continue
+		}
+		if version, ok := pep440Floor(clause); ok {
+			floor = version
+		} else if !rePep440Specifier.MatchString(clause) {
+			return "", false
+		}
+	}
+	return floor, floor != ""
+}
+
+var (
+	reTomlTable    = regexp.MustCompile(`^\s*\[\[?\s*([^\[\]]+?)\s*\]\]?\s*(#.*)?$`)
+	reTomlKeyValue = regexp.MustCompile(`^\s*(?:"([^"]+)"|'([^']+)'|([A-Za-z0-9_.-]+))\s*=\s*(.*?)\s*$`)
+	reTomlString   = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+	reTomlVersion  = regexp.MustCompile(`\bversion\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// tomlTable returns the name of the table a TOML line starts, e.g. tool.poetry.dependencies
+func tomlTable(line string) (string, bool) {
+	m := reTomlTable.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return strings.NewReplacer(`"`, "", `'`, "", " ", "").Replace(m[1]), true
+}
+
+// tomlKeyValue splits a TOML line into its key and unparsed value
+func tomlKeyValue(line string) (string, string, bool) {
+	m := reTomlKeyValue.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1] + m[2] + m[3], m[4], true
+}
+
+// tomlStrings returns the strings in a TOML value, e.g. the items of an array
+func tomlStrings(value string) []string {
+	var strs []string
+	for _, m := range reTomlString.FindAllStringSubmatch(value, -1) {
+		strs = append(strs, m[1]+m[2])
+	}
+	return strs
+}
+
+// tomlSpec returns the version constraint of a dependency given as a string or an inline table with a version
+func tomlSpec(value string) string {
+	if strings.HasPrefix(value, "{") {
+		if m := reTomlVersion.FindStringSubmatch(value); m != nil {
+			return m[1] + m[2]
+		}
+		return ""
+	}
+	if strs := tomlStrings(value); len(strs) > 0 {
+		return strs[0]
+	}
+	return ""
+}
+
+// componentsFromPipfile finds the pinned packages of a Pipfile's [packages], [dev-packages] and custom category tables
+func componentsFromPipfile(lines []patchLine) map[int64]component {
+	notPackages := map[string]bool{"": true, "source": true, "requires": true, "scripts": true, "pipenv": true}
+
+	found := make(map[int64]component)
+	var table string
+	for _, l := range lines {
+		if t, ok := tomlTable(l.text); ok {
+			table = t
+			continue
+		}
+		if notPackages[table] {
+			continue
+		}
+		name, value, ok := tomlKeyValue(l.text)
+		if !ok {
+			continue
+		}
+		if version, ok := pypiSpecVersion(tomlSpec(value)); ok {
+			found[l.location.Line] = component{format: "pypi", name: normalizePypiName(name), version: version}
+		}
+	}
+	return found
+}
+
+// componentsFromPipfileLock finds the packages of a Pipfile.lock, whose versions are pinned as "version": "==1.2.3"
+func componentsFromPipfileLock(lines []patchLine) map[int64]component {
+	reKey := regexp.MustCompile(`^\s*"([^"]+)"\s*:\s*\{`)
+	reVersion := regexp.MustCompile(`^\s*"version"\s*:\s*"([^"]*)"`)
+	notPackages := map[string]bool{"_meta": true, "default": true, "develop": true, "hash": true, "requires": true}
+
+	found := make(map[int64]component)
+	var pkg string
+	for _, l := range lines {
+		if m := reKey.FindStringSubmatch(l.text); m != nil {
+			pkg = m[1]
+			if notPackages[pkg] {
+				pkg = ""
+			}
+			continue
+		}
+		if m := reVersion.FindStringSubmatch(l.text); m != nil && pkg != "" {
+			if version, ok := pypiSpecVersion(m[1]); ok {
+				found[l.location.Line] = component{format: "pypi", name: normalizePypiName(pkg), version: version}
+			}
+		}
+	}
+	return found
+}
+
+// componentsFromPoetryLock finds the packages of a poetry.lock, each a [[package]] table with a name and version
+func componentsFromPoetryLock(lines []patchLine) map[int64]component {
+	found := make(map[int64]component)
+	var (
+		inPackage bool
+		name      string
+	)
+	for _, l := range lines {
+		if t, ok := tomlTable(l.text); ok {
+			inPackage, name = t == "package" && strings.HasPrefix(strings.TrimSpace(l.text), "[["), ""
+			continue
+		}
+		if !inPackage {
+			continue
+		}
+		key, value, ok := tomlKeyValue(l.text)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "name":
+			name = tomlSpec(value)
+		case "version":
+			if name != "" {
+				found[l.location.Line] = component{format: "pypi", name: normalizePypiName(name), version: tomlSpec(value)}
+			}
+		}
+	}
+	return found
+}
+
+var rePoetryGroupDependencies = regexp.MustCompile(`^tool\.poetry\.group\.[^.]+\.dependencies$`)
+
+// componentsFromPyproject finds the pinned dependencies of a pyproject.toml, both the PEP 621 arrays of requirements
+// under [project] and [project.optional-dependencies] and the tables of Poetry's [tool.poetry.*dependencies]
+func componentsFromPyproject(lines []patchLine) map[int64]component {
+	isRequirementsArray := func(table, key string) bool {
+		return (table == "project" && key == "dependencies") ||
+			table == "project.optional-dependencies" ||
+			table == "dependency-groups"
+	}
+	isPoetryTable := func(table string) bool {
+		return table == "tool.poetry.dependencies" ||
+			table == "tool.poetry.dev-dependencies" ||
+			rePoetryGroupDependencies.MatchString(table)
+	}
+	// closesArray checks for the end of an array outside of its strings, as extras like requests[socks] have brackets
+	closesArray := func(text string) bool {
+		return strings.Contains(reTomlString.ReplaceAllString(text, ""), "]")
+	}
+	firstRequirement := func(text string) (component, bool) {
+		for _, s := range tomlStrings(text) {
+			if c, ok := pypiRequirement(s); ok {
+				return c, true
+			}
+		}
+		return component{}, false
+	}
+
+	found := make(map[int64]component)
+	var (
+		table   string
+		inArray bool
+	)
+	for _, l := range lines {
+		if inArray {
+			if c, ok := firstRequirement(l.text); ok {
+				found[l.location.Line] = c
+			}
+			inArray = !closesArray(l.text)
+			continue
+		}
+
+		if t, ok := tomlTable(l.text); ok {
+			table = t
+			continue
+		}
+		key, value, ok := tomlKeyValue(l.text)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case isRequirementsArray(table, key) && strings.HasPrefix(value, "["):
+			if c, ok := firstRequirement(value); ok {
+				found[l.location.Line] = c
+			}
+			inArray = !closesArray(value[1:])
+		case isPoetryTable(table) && key != "python":
+			if version, ok := poetrySpecVersion(tomlSpec(value)); ok {
+				found[l.location.Line] = component{format: "pypi", name: normalizePypiName(key), version: version}
+			}
+		}
+	}
+	return found
+}
+
+var reRequirementsInclude = regexp.MustCompile(`^\s*(?:-r|--requirement|-c|--constraint)(?:\s+|\s*=\s*)(\S+)`)
+
+// requirementsIncludes finds the requirements files included by -r or -c from the changed requirements files,
+// so those with names which don't follow the usual conventions, e.g. base.pip, are reviewed as well
+func requirementsIncludes(files []changedFile, src manifestSource) map[string]bool {
+	changed := make(map[string]changedFile, len(files))
+	var pending []changedFile
+	for _, f := range files {
+		changed[f.Filename] = f
+		if isRequirementsFile(f.Filename) {
+			pending = append(pending, f)
+		}
+	}
+
+	included := make(map[string]bool)
+	for len(pending) > 0 {
+		f := pending[0]
+		pending = pending[1:]
+		for _, l := range manifestLines(f, src) {
+			m := reRequirementsInclude.FindStringSubmatch(l.text)
+			if m == nil || strings.Contains(m[1], "://") {
+				continue
+			}
+			name := path.Join(path.Dir(f.Filename), m[1])
+			if inc, ok := changed[name]; ok && !included[name] {
+				included[name] = true
+				pending = append(pending, inc)
+			}
+		}
+	}
+	return included
+}
+
+// isRequirementsFile returns true for files following pip's and pip-tools' naming conventions, e.g. requirements-dev.txt,
+// which are the manifestParsers patterns naming requirements
+func isRequirementsFile(filename string) bool {
+	for _, m := range manifestParsers {
+		if strings.Contains(m.pattern, "requirements") && matchManifestPattern(m.pattern, filename) {
+			return true
+		}
+	}
+	return false
+}
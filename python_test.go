@@ -0,0 +1,285 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	testPipfile = `[[source]]
+url = "https://pypi.org/simple"
+verify_ssl = true
+name = "pypi"
+
+[packages]
+requests = "==2.31.0"
+flask = {version = "==0.10.1", extras = ["async"]}
+django = "*"
+PyYAML = ">=6.0,<7"
+
+[dev-packages]
+pytest = "==7.4.0"
+
+[requires]
+python_version = "3.11"`
+
+	testPipfileLock = `{
+    "_meta": {
+        "hash": {
+            "sha256": "abc"
+        },
+        "requires": {
+            "python_version": "3.11"
+        }
+    },
+    "default": {
+        "requests": {
+            "hashes": [
+                "sha256:def"
+            ],
+            "index": "pypi",
+            "version": "==2.31.0"
+        }
+    },
+    "develop": {}
+}`
+
+	testPoetryLock = `[[package]]
+name = "requests"
+version = "2.31.0"
+description = "Python HTTP for Humans."
+optional = false
+
+[package.dependencies]
+certifi = ">=2017.4.17"
+
+[[package]]
+name = "urllib3"
+version = "2.0.4"
+
+[metadata]
+lock-version = "2.0"`
+
+	testPyproject = `[project]
+name = "app"
+version = "1.0.0"
+dependencies = [
+    "requests[socks]==2.31.0",
+    "flask>=2",
+]
+
+[project.optional-dependencies]
+test = ["pytest==7.4.0"]
+
+[tool.poetry.dependencies]
+python = "^3.11"
+django = "4.2.4"
+celery = {version = "5.3.1", extras = ["redis"]}
+numpy = "^1.25"
+
+[tool.poetry.group.dev.dependencies]
+black = "==23.7.0"`
+)
+
+func Test_pythonManifests(t *testing.T) {
+	tests := []struct {
+		filename, content string
+		want              map[changeLocation]component
+	}{
+		{
+			"Pipfile",
+			testPipfile,
+			map[changeLocation]component{
+				changeLocation{Position: 7, Line: 7}:   component{format: "pypi", name: "requests", version: "2.31.0"},
+				changeLocation{Position: 8, Line: 8}:   component{format: "pypi", name: "flask", version: "0.10.1"},
+				changeLocation{Position: 10, Line: 10}: component{format: "pypi", name: "pyyaml", version: "6.0"},
+				changeLocation{Position: 13, Line: 13}: component{format: "pypi", name: "pytest", version: "7.4.0"},
+			},
+		},
+		{
+			"Pipfile.lock",
+			testPipfileLock,
+			map[changeLocation]component{
+				changeLocation{Position: 16, Line: 16}: component{format: "pypi", name: "requests", version: "2.31.0"},
+			},
+		},
+		{
+			"poetry.lock",
+			testPoetryLock,
+			map[changeLocation]component{
+				changeLocation{Position: 3, Line: 3}:   component{format: "pypi", name: "requests", version: "2.31.0"},
+				changeLocation{Position: 12, Line: 12}: component{format: "pypi", name: "urllib3", version: "2.0.4"},
+			},
+		},
+		{
+			"pyproject.toml",
+			testPyproject,
+			map[changeLocation]component{
+				changeLocation{Position: 5, Line: 5}:   component{format: "pypi", name: "requests", version: "2.31.0"},
+				changeLocation{Position: 6, Line: 6}:   component{format: "pypi", name: "flask", version: "2"},
+				changeLocation{Position: 10, Line: 10}: component{format: "pypi", name: "pytest", version: "7.4.0"},
+				changeLocation{Position: 14, Line: 14}: component{format: "pypi", name: "django", version: "4.2.4"},
+				changeLocation{Position: 15, Line: 15}: component{format: "pypi", name: "celery", version: "5.3.1"},
+				changeLocation{Position: 16, Line: 16}: component{format: "pypi", name: "numpy", version: "1.25"},
+				changeLocation{Position: 19, Line: 19}: component{format: "pypi", name: "black", version: "23.7.0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			f := changedFile{Filename: "app/" + tt.filename, Patch: newFilePatch(tt.content)}
+			got, err := manifestParserFor(f.Filename)(f, manifestSource{})
+			if err != nil {
+				t.Fatalf("%s error = %v", tt.filename, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Error(tt.filename)
+				t.Errorf(" Got: %v\n", got)
+				t.Errorf("Want: %v\n", tt.want)
+			}
+		})
+	}
+}
+
+func Test_pythonManifests_patchOnly(t *testing.T) {
+	// Without the full file, only the hunk which shows its table is understood
+	patch := `@@ -12,5 +12,5 @@ test = ["pytest==7.4.0"]
+ [tool.poetry.dependencies]
+ python = "^3.11"
+-django = "4.2.3"
++django = "4.2.4"
+ celery = {version = "5.3.1", extras = ["redis"]}
+@@ -18,2 +18,2 @@
+-black = "==23.3.0"
++black = "==23.7.0"`
+	want := map[changeLocation]component{
+		changeLocation{Position: 4, Line: 14}: component{format: "pypi", name: "django", version: "4.2.4"},
+	}
+
+	f := changedFile{Filename: "pyproject.toml", Patch: patch}
+	got, err := manifestParserFor(f.Filename)(f, manifestSource{})
+	if err != nil {
+		t.Fatalf("pyproject.toml error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("pyproject.toml")
+		t.Errorf(" Got: %v\n", got)
+		t.Errorf("Want: %v\n", want)
+	}
+}
+
+func Test_requirementsIncludes(t *testing.T) {
+	files := []changedFile{
+		{Filename: "api/requirements.txt", Patch: "@@ -1,2 +1,2 @@\n -r base.pip\n-flask==0.10.1\n+flask==0.12.3"},
+		{Filename: "api/base.pip", Patch: "@@ -1,2 +1,3 @@\n --constraint=../constraints.pip\n+requests==2.31.0\n six==1.16.0"},
+		{Filename: "constraints.pip", Patch: "@@ -1,1 +1,1 @@\n-idna==3.3\n+idna==3.4"},
+		{Filename: "docs/other.pip", Patch: "@@ -1,1 +1,1 @@\n-sphinx==7.1.0\n+sphinx==7.2.0"},
+	}
+	want := map[string]bool{"api/base.pip": true, "constraints.pip": true}
+
+	if got := requirementsIncludes(files, manifestSource{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("requirementsIncludes() = %v, want %v", got, want)
+	}
+
+	manifests, err := findComponentsFromManifest(files, manifestSource{})
+	if err != nil {
+		t.Fatalf("findComponentsFromManifest() error = %v", err)
+	}
+	if len(manifests) != 3 {
+		t.Errorf("findComponentsFromManifest() found %d manifests, want 3", len(manifests))
+	}
+}
+
+func Test_pypiRequirement(t *testing.T) {
+	tests := []struct {
+		name        string
+		requirement string
+		want        component
+		wantOk      bool
+	}{
+		{"pinned", "flask==0.10.1", component{format: "pypi", name: "flask", version: "0.10.1"}, true},
+		{"indented", "    flask == 0.10.1", component{format: "pypi", name: "flask", version: "0.10.1"}, true},
+		{"comment", "jinja2==2.10 # via flask", component{format: "pypi", name: "jinja2", version: "2.10"}, true},
+		{"extras", "requests[security, socks]==2.8.1", component{format: "pypi", name: "requests", version: "2.8.1"}, true},
+		{"markers", `pywin32==306 ; sys_platform == "win32"`, component{format: "pypi", name: "pywin32", version: "306"}, true},
+		{"parenthesized", "requests (==2.8.1)", component{format: "pypi", name: "requests", version: "2.8.1"}, true},
+		{"normalized name", "Flask_SQLAlchemy==3.0.5", component{format: "pypi", name: "flask-sqlalchemy", version: "3.0.5"}, true},
+		{"hash continuation", `flask==2.3.2 \`, component{format: "pypi", name: "flask", version: "2.3.2"}, true},
+		{"inline hash", "flask==2.3.2 --hash=sha256:abc", component{format: "pypi", name: "flask", version: "2.3.2"}, true},
+		{"lower bound", "django>=4.2,<5", component{format: "pypi", name: "django", version: "4.2"}, true},
+		{"compatible release", "django~=4.2.1", component{format: "pypi", name: "django", version: "4.2.1"}, true},
+		{"pin and bound", "django>=4,==4.2.4", component{format: "pypi", name: "django", version: "4.2.4"}, true},
+		{"hash line", "    --hash=sha256:abc", component{}, false},
+		{"option", "--index-url https://pypi.example.com/simple", component{}, false},
+		{"include", "-r base.txt", component{}, false},
+		{"unpinned", "flask", component{}, false},
+		{"only upper bound", "flask<3", component{}, false},
+		{"exclusive lower bound", "flask>2", component{}, false},
+		{"wildcard", "flask==2.*", component{}, false},
+		{"excluded floor", "flask>=2.0,!=2.0", component{}, false},
+		{"url", "flask @ https://example.com/flask-2.3.2.tar.gz", component{}, false},
+		{"local path", "./packages/flask", component{}, false},
+		{"comment line", "# flask==2.3.2", component{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pypiRequirement(tt.requirement)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("pypiRequirement(%q) = %v, %v, want %v, %v", tt.requirement, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_poetrySpecVersion(t *testing.T) {
+	tests := []struct {
+		spec   string
+		want   string
+		wantOk bool
+	}{
+		{"4.2.4", "4.2.4", true},
+		{"==4.2.4", "4.2.4", true},
+		{"^1.25", "1.25", true},
+		{"~1.2.3", "1.2.3", true},
+		{">= 1.2, < 1.5", "1.2", true},
+		{">=1.2 <1.5", "1.2", true},
+		{"*", "", false},
+		{"<2", "", false},
+		{"^1.2 || ^2.0", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, ok := poetrySpecVersion(tt.spec)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("poetrySpecVersion(%q) = %q, %v, want %q, %v", tt.spec, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_suggestPypiLine(t *testing.T) {
+	tests := []struct {
+		name                       string
+		line, original, remediated string
+		want                       string
+	}{
+		{"exact", `flask==2.0`, "2.0", "2.3.3", `flask==2.3.3`},
+		{"minimum", `flask>=2.0 # web`, "2.0", "2.3.3", `flask>=2.3.3 # web`},
+		{"range still allows the version", `flask>=2.0,<3`, "2.0", "2.3.3", `flask>=2.3.3,<3`},
+		{"upper bound below the version", `flask>=2.0,<2.1`, "2.0", "2.3.3", ""},
+		{"inclusive upper bound below the version", `flask >= 2.0, <= 2.2.9`, "2.0", "2.3.3", ""},
+		{"excluded version", `flask>=2.0,!=2.3.3`, "2.0", "2.3.3", ""},
+		{"compatible release", `flask~=2.0`, "2.0", "2.3", `flask~=2.3`},
+		{"wildcard exclusion", `flask>=2.0,!=2.3.*`, "2.0", "2.3.3", ""},
+		{"pipfile", `flask = ">=2.0,<2.1"`, "2.0", "2.3.3", ""},
+		{"poetry", `flask = "^2.0"`, "2.0", "2.3.3", `flask = "^2.3.3"`},
+		{"pre-release can't be compared", `flask>=2.0,<3`, "2.0", "2.3.0rc1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestAllowedLine(tt.line, tt.original, tt.remediated, pypiLineAllows); got != tt.want {
+				t.Errorf("suggestAllowedLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
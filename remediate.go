@@ -4,8 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/template"
 
+	"github.com/package-url/packageurl-go"
+
 	nexusiq "github.com/sonatype-nexus-community/gonexus/iq"
 )
 
@@ -20,58 +26,208 @@ type changedFile struct {
 	Filename, Patch string
 }
 
-var commentTmpl = "[Nexus Lifecycle](https://www.sonatype.com/product-nexus-lifecycle) has found that this version of " +
+// commentIntro starts every remediation comment, which tells them apart from the other comments on a request
+const commentIntro = "[Nexus Lifecycle](https://www.sonatype.com/product-nexus-lifecycle) has found"
+
+var commentTmpl = commentIntro + " that this version of " +
 	"`{{.Name}}` violates your company's policies.\n\n" +
-	"Lifecycle recommends using version [{{.Version}}]({{.Href}}) instead as it does not violate any policies.\n\n"
+	"Lifecycle recommends using version [{{.Version}}]({{.Href}}) instead as it does not violate any policies.\n\n" +
+	"{{if .Suggestion}}{{.SuggestionFence}}\n{{.Suggestion}}\n```\n{{end}}"
+
+// The opening fences of a suggested change which replaces the commented line
+const (
+	githubSuggestionFence = "```suggestion"
+	gitlabSuggestionFence = "```suggestion:-0+0"
+)
+
+// suggestLine rewrites a manifest line to use the remediated version instead of the original one.
+// Returns an empty string if the original version is not in the line.
+func suggestLine(line, originalVersion, remediatedVersion string) string {
+	if originalVersion == "" {
+		return ""
+	}
+
+	isVersionChar := func(b byte) bool {
+		return b == '.' || b == '-' || b == '+' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+	}
+
+	// Use the last standalone occurrence as versions follow the name in every manifest format
+	for i := strings.LastIndex(line, originalVersion); i >= 0; i = strings.LastIndex(line[:i], originalVersion) {
+		end := i + len(originalVersion)
+		if (i > 0 && isVersionChar(line[i-1])) || (end < len(line) && isVersionChar(line[end])) {
+			continue
+		}
+		return line[:i] + remediatedVersion + line[end:]
+	}
+
+	return ""
+}
+
+// suggestAllowedLine rewrites a manifest line like suggestLine, but only if the version constraints left in the line still allow the remediated version.
+// Replacing the lower bound of a range could otherwise leave it above the upper bound, e.g. flask>=2.3.3,<2.1.
+func suggestAllowedLine(line, originalVersion, remediatedVersion string, allows func(line, version string) bool) string {
+	suggestion := suggestLine(line, originalVersion, remediatedVersion)
+	if suggestion == "" || !allows(suggestion, remediatedVersion) {
+		return ""
+	}
+	return suggestion
+}
+
+// compareReleaseVersions compares versions made of numeric segments, treating missing segments as 0, e.g. 2.1 < 2.3.3 and 1.0 == 1.0.0.
+// Returns false if either version has anything else, such as a pre-release, as ordering those depends on the ecosystem.
+func compareReleaseVersions(a, b string) (int, bool) {
+	segments := func(v string) ([]int, bool) {
+		var nums []int
+		for _, s := range strings.Split(v, ".") {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, false
+			}
+			nums = append(nums, n)
+		}
+		return nums, true
+	}
+	as, aok := segments(a)
+	bs, bok := segments(b)
+	if !aok || !bok {
+		return 0, a == b
+	}
+
+	for len(as) < len(bs) {
+		as = append(as, 0)
+	}
+	for len(bs) < len(as) {
+		bs = append(bs, 0)
+	}
+	for i := range as {
+		switch {
+		case as[i] < bs[i]:
+			return -1, true
+		case as[i] > bs[i]:
+			return 1, true
+		}
+	}
+	return 0, true
+}
 
 type component struct {
 	format, group, name, version string
+	// kind is the type of a maven artifact when it isn't a jar, e.g. pom for parents and BOMs,
+	// or the platform of a gem built for one, e.g. java or x86_64-linux
+	kind string
 }
 
+// mavenType returns the type of a maven artifact, which is a jar unless it says otherwise
+func (c component) mavenType() string {
+	if c.kind != "" {
+		return c.kind
+	}
+	return "jar"
+}
+
+// gemPlatform returns the platform of a gem, which is plain ruby unless it was built for another one
+func (c component) gemPlatform() string {
+	if c.kind != "" {
+		return c.kind
+	}
+	return "ruby"
+}
+
+var reGoMajorVersionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// golangComponent creates a go module component, splitting its path into the namespace and name of its package URL as the purl spec does,
+// e.g. github.com/gorilla and mux. A major version suffix stays with the name, so github.com/go-redis/redis/v8 is github.com/go-redis and redis/v8.
+func golangComponent(modulePath, version string) component {
+	c := component{format: "golang", name: modulePath, version: version}
+	i := strings.LastIndex(modulePath, "/")
+	if i > 0 && reGoMajorVersionSuffix.MatchString(modulePath[i+1:]) {
+		i = strings.LastIndex(modulePath[:i], "/")
+	}
+	if i > 0 {
+		c.group, c.name = modulePath[:i], modulePath[i+1:]
+	}
+	return c
+}
+
+// modulePath returns the path of a go module from its namespace and name
+func (c component) modulePath() string {
+	if c.group == "" {
+		return c.name
+	}
+	return c.group + "/" + c.name
+}
+
+// pypi packages are published as source distributions and wheels, either of which IQ may know them by
+var (
+	pypiSdistQualifiers = packageurl.Qualifiers{{Key: "extension", Value: "tar.gz"}}
+	pypiWheelQualifiers = []packageurl.Qualifiers{
+		{{Key: "extension", Value: "whl"}, {Key: "qualifier", Value: "py3-none-any"}},
+		{{Key: "extension", Value: "whl"}, {Key: "qualifier", Value: "py2.py3-none-any"}},
+	}
+)
+
 func (c component) purl() string {
-	/*
-		purl := packageurl.NewPackageURL(c.format, c.group, c.name, c.version, nil, nil)
-		if purl == nil {
-			return errors.New("could not create PackageURL string")
-		}
-		return purl.ToString()
-	*/
 	switch c.format {
 	case "npm":
+		if c.group != "" {
+			return fmt.Sprintf("pkg:npm/%s/%s@%s", url.QueryEscape(c.group), c.name, c.version)
+		}
 		return fmt.Sprintf("pkg:npm/%s@%s", c.name, c.version)
 	case "nuget":
 		return fmt.Sprintf("pkg:nuget/%s@%s", c.name, c.version)
 	case "pypi":
-		return fmt.Sprintf("pkg:pypi/%s@%s?extension=%s", c.name, c.version, "tar.gz")
+		return packageurl.NewPackageURL("pypi", "", c.name, c.version, pypiSdistQualifiers, "").ToString()
 	case "maven":
-		return fmt.Sprintf("pkg:maven/%s/%s@%s?type=%s", c.group, c.name, c.version, "jar")
+		return fmt.Sprintf("pkg:maven/%s/%s@%s?type=%s", c.group, c.name, c.version, c.mavenType())
 	case "golang":
-		return fmt.Sprintf("pkg:golang/%s@%s", c.name, c.version)
+		return fmt.Sprintf("pkg:golang/%s@%s", c.modulePath(), c.version)
 	case "ruby":
-		return fmt.Sprintf("pkg:gem/%s@%s?platform=ruby", c.name, c.version)
+		return fmt.Sprintf("pkg:gem/%s@%s?platform=%s", c.name, c.version, c.gemPlatform())
+	case "cargo":
+		return fmt.Sprintf("pkg:cargo/%s@%s", c.name, c.version)
 	default:
 		return ""
 	}
 }
 
-func addRemediationComments(remediations componentRemediations, addComment addCommentFunc) error {
-	comment := func(c component) string {
+// alternatePurls returns other package URLs the component may be known by if IQ doesn't know its main one
+func (c component) alternatePurls() []string {
+	var purls []string
+	if c.format == "pypi" {
+		for _, q := range pypiWheelQualifiers {
+			purls = append(purls, packageurl.NewPackageURL("pypi", "", c.name, c.version, q, "").ToString())
+		}
+	}
+	return purls
+}
+
+// addRemediationComments comments on each manifest line which has a remediation with a suggested change using the given fence
+func addRemediationComments(manifests, remediations componentRemediations, suggestionFence string, addComment addCommentFunc) error {
+	comment := func(c component, suggestion string) string {
+		name := c.name
 		var href string
 		switch c.format {
 		case "npm":
-			href = fmt.Sprintf("https://www.npmjs.com/package/%s/v/%s", c.name, c.version)
+			name := c.name
+			if c.group != "" {
+				name = c.group + "/" + c.name
+			}
+			href = fmt.Sprintf("https://www.npmjs.com/package/%s/v/%s", name, c.version)
 		case "maven":
-			href = fmt.Sprintf("https://search.maven.org/artifact/%s/%s/%s/jar", c.group, c.name, c.version)
+			href = fmt.Sprintf("https://search.maven.org/artifact/%s/%s/%s/%s", c.group, c.name, c.version, c.mavenType())
 		case "nuget":
 			href = fmt.Sprintf("https://www.nuget.org/packages/%s/%s", c.name, c.version)
 		case "pypi":
 			href = fmt.Sprintf("https://pypi.org/project/%s/%s", c.name, c.version)
 		case "golang":
-			href = fmt.Sprintf("https://%s/%s/releases/tag/%s", c.group, c.name, c.version)
+			name = c.modulePath()
+			href = fmt.Sprintf("https://pkg.go.dev/%s@%s", name, c.version)
 		case "ruby":
 			fallthrough
 		case "gem":
 			href = fmt.Sprintf("https://rubygems.org/gems/%s/versions/%s", c.name, c.version)
+		case "cargo":
+			href = fmt.Sprintf("https://crates.io/crates/%s/%s", c.name, c.version)
 		}
 
 		tmpl, err := template.New("comment").Parse(commentTmpl)
@@ -81,7 +237,7 @@ func addRemediationComments(remediations componentRemediations, addComment addCo
 		}
 
 		var comment bytes.Buffer
-		err = tmpl.Execute(&comment, struct{ Name, Version, Href string }{c.name, c.version, href})
+		err = tmpl.Execute(&comment, struct{ Name, Version, Href, Suggestion, SuggestionFence string }{name, c.version, href, suggestion, suggestionFence})
 		if err != nil {
 			log.Printf("%v\n", err)
 			return ""
@@ -91,8 +247,23 @@ func addRemediationComments(remediations componentRemediations, addComment addCo
 	}
 
 	for m, components := range remediations {
+		lines := parsePatchLineAdditions(m.Patch)
 		for pos, comp := range components {
-			err := addComment(m.Filename, pos, comment(comp))
+			var suggestion string
+			switch {
+			case isLockfile(m.Filename):
+			case comp.format == "npm":
+				suggestion = suggestNpmLine(lines[pos], comp.version)
+			case comp.format == "pypi":
+				suggestion = suggestAllowedLine(lines[pos], manifests[m][pos].version, comp.version, pypiLineAllows)
+			case comp.format == "nuget":
+				suggestion = suggestAllowedLine(lines[pos], manifests[m][pos].version, comp.version, nugetLineAllows)
+			case comp.format == "cargo":
+				suggestion = suggestAllowedLine(lines[pos], manifests[m][pos].version, comp.version, cargoLineAllows)
+			default:
+				suggestion = suggestLine(lines[pos], manifests[m][pos].version, comp.version)
+			}
+			err := addComment(m.Filename, pos, comment(comp, suggestion))
 			if err != nil {
 				log.Printf("WARN: could not add comment: %s", err)
 			}
@@ -102,23 +273,92 @@ func addRemediationComments(remediations componentRemediations, addComment addCo
 	return nil
 }
 
-func addRemediationsToRequest(iq nexusiq.IQ, iqApp string, files []changedFile, addComment addCommentFunc) error {
-	manifests, err := findComponentsFromManifest(files)
+// onlyChangedLines drops any components which are not on a line added by the given changes
+func onlyChangedLines(manifests componentRemediations, changes []changedFile) componentRemediations {
+	added := make(map[string]map[int64]bool)
+	for _, f := range changes {
+		lines := make(map[int64]bool)
+		for loc := range parsePatchLineAdditions(f.Patch) {
+			lines[loc.Line] = true
+		}
+		added[f.Filename] = lines
+	}
+
+	filtered := make(componentRemediations)
+	for m, components := range manifests {
+		kept := make(map[changeLocation]component)
+		for loc, c := range components {
+			if added[m.Filename][loc.Line] {
+				kept[loc] = c
+			}
+		}
+		if len(kept) > 0 {
+			filtered[m] = kept
+		}
+	}
+
+	return filtered
+}
+
+// commentedLines are the lines of each file which already have a remediation comment
+type commentedLines map[string]map[int64]bool
+
+// add records a comment if it is a remediation comment on a line of the file
+func (c commentedLines) add(filename string, line int64, body string) {
+	if line == 0 || !strings.HasPrefix(body, commentIntro) {
+		return
+	}
+	if c[filename] == nil {
+		c[filename] = make(map[int64]bool)
+	}
+	c[filename][line] = true
+}
+
+// withoutCommentedLines drops any components on a line which already has a remediation comment,
+// e.g. because the request was reviewed before it was reopened
+func withoutCommentedLines(manifests componentRemediations, commented commentedLines) componentRemediations {
+	filtered := make(componentRemediations)
+	for m, components := range manifests {
+		kept := make(map[changeLocation]component)
+		for loc, c := range components {
+			if !commented[m.Filename][loc.Line] {
+				kept[loc] = c
+			}
+		}
+		if len(kept) > 0 {
+			filtered[m] = kept
+		}
+	}
+
+	return filtered
+}
+
+// addRemediationsToRequest comments on the components added by the request's files, except on lines which already have a remediation comment.
+// If changes is not nil, only components on lines added by those changes are reviewed.
+func addRemediationsToRequest(iq nexusiq.IQ, repo repoConfig, files, changes []changedFile, commented commentedLines, suggestionFence string, content fileContentFunc, addComment addCommentFunc) error {
+	iqApps := repo.iqApplications()
+	manifests, err := findComponentsFromManifest(files, manifestSource{content: content, npmSections: repo.npmSections()})
 	if err != nil {
 		log.Printf("ERROR: could not read files to find manifest: %v\n", err)
 		return fmt.Errorf("could not read files to find manifest: %v", err)
 	}
 	log.Printf("TRACE: Found manifests and added components: %q\n", manifests)
 
-	remediations, err := getComponentRemediations(iq, iqApp, manifests)
+	if changes != nil {
+		manifests = onlyChangedLines(manifests, changes)
+		log.Printf("TRACE: Components added by latest changes: %q\n", manifests)
+	}
+	manifests = withoutCommentedLines(manifests, commented)
+
+	remediations, err := getComponentRemediations(iq, iqApps, manifests)
 	if err != nil {
 		log.Printf("ERROR: could not find remediation version for components: %v\n", err)
 		return fmt.Errorf("could not find remediation version for components: %v", err)
 	}
-	log.Printf("TRACE: retrieved %d remediations based on IQ app %s\n", len(remediations), iqApp)
+	log.Printf("TRACE: retrieved %d remediations based on IQ apps %v\n", len(remediations), iqApps)
 
 	// if err = addRemediationsToPullRequest(token, pull, remediations); err != nil {
-	if err = addRemediationComments(remediations, addComment); err != nil {
+	if err = addRemediationComments(manifests, remediations, suggestionFence, addComment); err != nil {
 		return fmt.Errorf("could not add PR comments: %v", err)
 	}
 
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	nexusiq "github.com/sonatype-nexus-community/gonexus/iq"
@@ -41,10 +44,10 @@ func Test_processPullRequestForRemediations(t *testing.T) {
 	}
 
 	type args struct {
-		iq    nexusiq.IQ
-		iqApp string
-		token string
-		pull  GithubPullRequest
+		iq   nexusiq.IQ
+		repo repoConfig
+		scm  scmConnection
+		pull GithubPullRequest
 	}
 	tests := []struct {
 		name    string
@@ -54,19 +57,188 @@ func Test_processPullRequestForRemediations(t *testing.T) {
 		{
 			"real data",
 			args{
-				iq:    iq,
-				token: token,
-				iqApp: "APP",
-				pull:  pull,
+				iq:   iq,
+				scm:  scmConnection{token: token, client: http.DefaultClient},
+				repo: repoConfig{IQApp: "APP"},
+				pull: pull,
 			},
 			false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := ProcessPullRequestForRemediations(tt.args.iq, tt.args.token, tt.args.iqApp, tt.args.pull); (err != nil) != tt.wantErr {
+			if err := ProcessPullRequestForRemediations(tt.args.iq, tt.args.repo, tt.args.scm, tt.args.pull); (err != nil) != tt.wantErr {
 				t.Errorf("processPullRequestForRemediations() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func Test_onlyChangedLines(t *testing.T) {
+	manifest := changedFile{Filename: "package.json", Patch: dummyPatches["package.json"]}
+	manifests := componentRemediations{
+		manifest: {
+			changeLocation{Position: 4, Line: 78}:   component{format: "npm", name: "chalk", version: "1.0.0"},
+			changeLocation{Position: 21, Line: 115}: component{format: "npm", name: "moment", version: "2.1.0"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		changes []changedFile
+		want    componentRemediations
+	}{
+		{
+			"latest push changed one dependency",
+			[]changedFile{{Filename: "package.json", Patch: `@@ -115,1 +115,1 @@
+- "moment": "^2.2.0",
++ "moment": "^2.1.0",`}},
+			componentRemediations{
+				manifest: {
+					changeLocation{Position: 21, Line: 115}: component{format: "npm", name: "moment", version: "2.1.0"},
+				},
+			},
+		},
+		{
+			"latest push did not touch the manifest",
+			[]changedFile{{Filename: "README.md", Patch: `@@ -1,1 +1,1 @@
+-old
++new`}},
+			componentRemediations{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := onlyChangedLines(manifests, tt.changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("onlyChangedLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withoutCommentedLines(t *testing.T) {
+	manifest := changedFile{Filename: "package.json", Patch: dummyPatches["package.json"]}
+	manifests := componentRemediations{
+		manifest: {
+			changeLocation{Position: 4, Line: 78}:   component{format: "npm", name: "chalk", version: "1.0.0"},
+			changeLocation{Position: 21, Line: 115}: component{format: "npm", name: "moment", version: "2.1.0"},
+		},
+	}
+
+	commented := make(commentedLines)
+	commented.add("package.json", 78, commentIntro+" that this version of `chalk` violates your company's policies.")
+	commented.add("package.json", 115, "Why this version of moment?")
+	commented.add("other/package.json", 115, commentIntro+" that this version of `moment` violates your company's policies.")
+
+	want := componentRemediations{
+		manifest: {
+			changeLocation{Position: 21, Line: 115}: component{format: "npm", name: "moment", version: "2.1.0"},
+		},
+	}
+	if got := withoutCommentedLines(manifests, commented); !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutCommentedLines() = %v, want %v", got, want)
+	}
+}
+
+func Test_suggestLine(t *testing.T) {
+	tests := []struct {
+		name                       string
+		line, original, remediated string
+		want                       string
+	}{
+		{"npm range", ` "moment": "^2.1.0",`, "2.1.0", "2.29.4", ` "moment": "^2.29.4",`},
+		{"maven", ` <version>1.2.1</version>`, "1.2.1", "1.2.2", ` <version>1.2.2</version>`},
+		{"gradle", ` compile group: 'axis', name: 'axis', version: '1.2'`, "1.2", "1.4", ` compile group: 'axis', name: 'axis', version: '1.4'`},
+		{"pypi comment", `jinja2==2.10 # via flask`, "2.10", "2.10.1", `jinja2==2.10.1 # via flask`},
+		{"not a standalone match", `"lib-1.2.3": "1.2.3.4"`, "1.2.3", "1.2.4", ""},
+		{"name contains version", `<package id="Lib1.2" version="1.2" />`, "1.2", "1.3", `<package id="Lib1.2" version="1.3" />`},
+		{"version not in line", `gem 'doorkeeper', '~> 4.3'`, "4.3.0", "4.5.0", ""},
+		{"no version", `foo`, "", "1.0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestLine(tt.line, tt.original, tt.remediated); got != tt.want {
+				t.Errorf("suggestLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addRemediationComments(t *testing.T) {
+	pom := changedFile{Filename: "pom.xml", Patch: dummyPatches["pom.xml"]}
+	manifests, err := findComponentsFromManifest([]changedFile{pom}, manifestSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := changeLocation{Position: 5, Line: 74}
+	remediations := componentRemediations{
+		pom: {loc: component{format: "maven", group: "axis", name: "axis", version: "1.4"}},
+	}
+
+	tests := []struct {
+		name  string
+		fence string
+		want  string
+	}{
+		{"github", githubSuggestionFence, "```suggestion\n <version>1.4</version>\n```\n"},
+		{"gitlab", gitlabSuggestionFence, "```suggestion:-0+0\n <version>1.4</version>\n```\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var comments []string
+			addRemediationComments(manifests, remediations, tt.fence, func(filename string, location changeLocation, comment string) error {
+				if filename != pom.Filename || location != loc {
+					t.Errorf("commented on %s %v", filename, location)
+				}
+				comments = append(comments, comment)
+				return nil
+			})
+
+			if len(comments) != 1 {
+				t.Fatalf("added %d comments, want 1", len(comments))
+			}
+			if !strings.HasSuffix(comments[0], tt.want) {
+				t.Errorf("comment = %q, want suggestion %q", comments[0], tt.want)
+			}
+		})
+	}
+}
+
+func Test_golangComponent(t *testing.T) {
+	tests := []struct {
+		modulePath    string
+		group, name   string
+		purl, comment string
+	}{
+		{"github.com/gorilla/mux", "github.com/gorilla", "mux", "pkg:golang/github.com/gorilla/mux@v1.8.0", "https://pkg.go.dev/github.com/gorilla/mux@v1.8.0"},
+		{"github.com/go-redis/redis/v8", "github.com/go-redis", "redis/v8", "pkg:golang/github.com/go-redis/redis/v8@v1.8.0", "https://pkg.go.dev/github.com/go-redis/redis/v8@v1.8.0"},
+		{"gopkg.in/yaml.v2", "gopkg.in", "yaml.v2", "pkg:golang/gopkg.in/yaml.v2@v1.8.0", "https://pkg.go.dev/gopkg.in/yaml.v2@v1.8.0"},
+		{"rsc.io/quote", "rsc.io", "quote", "pkg:golang/rsc.io/quote@v1.8.0", "https://pkg.go.dev/rsc.io/quote@v1.8.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.modulePath, func(t *testing.T) {
+			c := golangComponent(tt.modulePath, "v1.8.0")
+			if c.group != tt.group || c.name != tt.name {
+				t.Errorf("golangComponent() = %q %q, want %q %q", c.group, c.name, tt.group, tt.name)
+			}
+			if got := c.modulePath(); got != tt.modulePath {
+				t.Errorf("modulePath() = %q, want %q", got, tt.modulePath)
+			}
+			if got := c.purl(); got != tt.purl {
+				t.Errorf("purl() = %q, want %q", got, tt.purl)
+			}
+
+			f := changedFile{Filename: "go.mod"}
+			loc := changeLocation{Position: 1, Line: 1}
+			var comment string
+			addRemediationComments(componentRemediations{f: {}}, componentRemediations{f: {loc: c}}, githubSuggestionFence, func(_ string, _ changeLocation, body string) error {
+				comment = body
+				return nil
+			})
+			if !strings.Contains(comment, "`"+tt.modulePath+"`") || !strings.Contains(comment, "("+tt.comment+")") {
+				t.Errorf("comment = %q, want module %s linked to %s", comment, tt.modulePath, tt.comment)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	reGemDeclaration  = regexp.MustCompile(`^\s*gem[\s(]+["']([^"']+)["']\s*(.*)$`)
+	reGemspecDep      = regexp.MustCompile(`\.add_(?:runtime_|development_)?dependency[\s(]+["']([^"']+)["']\s*(.*)$`)
+	reGemString       = regexp.MustCompile(`^["']([^"']*)["']`)
+	reGemNonRegistry  = regexp.MustCompile(`(?:^|[\s,{])(?:git|github|gist|bitbucket|path)\s*:|:(?:git|github|gist|bitbucket|path)\s*=>`)
+	reGemRequirement  = regexp.MustCompile(`^\s*(=|!=|>=|<=|~>|>|<)?\s*([0-9][0-9A-Za-z.]*)\s*$`)
+	reGemLockSpec     = regexp.MustCompile(`^    ([^\s(]+) \(([^)\s]+)\)\s*$`)
+	reGemLockPlatform = regexp.MustCompile(`^([0-9][0-9A-Za-z.]*)(?:-(.+))?$`)
+)
+
+// gemRequirements returns the version requirements given after a gem's name, e.g. "~> 1.2", ">= 1.2.3" for
+// gem 'x', '~> 1.2', '>= 1.2.3', require: false. Returns false if the gem isn't installed from a registry, e.g. from git or a path.
+func gemRequirements(args string) ([]string, bool) {
+	if reGemNonRegistry.MatchString(args) {
+		return nil, false
+	}
+
+	var requirements []string
+	for {
+		args = strings.TrimLeft(args, ", \t")
+		m := reGemString.FindStringSubmatch(args)
+		if m == nil {
+			// The requirements are followed by options such as require: false or group: :test
+			return requirements, true
+		}
+		requirements = append(requirements, m[1])
+		args = args[len(m[0]):]
+	}
+}
+
+// gemPinnedVersion returns the version of an exact requirement, such as '1.2.3' or '= 1.2.3'.
+// Other requirements, such as '~> 4.5', don't name a version which is sure to exist so their version comes from the lockfile.
+func gemPinnedVersion(requirements []string) (string, bool) {
+	for _, r := range requirements {
+		if m := reGemRequirement.FindStringSubmatch(r); m != nil && (m[1] == "" || m[1] == "=") {
+			return m[2], true
+		}
+	}
+	return "", false
+}
+
+// gemLockSpecs reads the gems a Gemfile.lock resolved from rubygems, i.e. the specs of its GEM sections, keyed by line.
+// The more indented entries under each spec are its dependencies' requirements.
+func gemLockSpecs(lines []patchLine) map[int64]component {
+	specs := make(map[int64]component)
+
+	// Without the whole file, lines before the first section header are assumed to be in a GEM section
+	section := "GEM"
+	for _, l := range lines {
+		if l.text != "" && l.text[0] != ' ' {
+			section = strings.TrimSpace(l.text)
+			continue
+		}
+		if section != "GEM" {
+			continue
+		}
+		m := reGemLockSpec.FindStringSubmatch(l.text)
+		if m == nil {
+			continue
+		}
+		// Gems built for a platform have it appended to their version, e.g. 1.12.5-x86_64-linux
+		v := reGemLockPlatform.FindStringSubmatch(m[2])
+		if v == nil {
+			continue
+		}
+		specs[l.location.Line] = component{format: "ruby", name: m[1], version: v[1], kind: v[2]}
+	}
+	return specs
+}
+
+// componentsFromGemfileLock finds the gems resolved by a Gemfile.lock.
+// Gems are listed once for each platform they were resolved for, so only the first added line of each version is kept.
+func componentsFromGemfileLock(lines []patchLine) map[int64]component {
+	specs := gemLockSpecs(lines)
+	found := make(map[int64]component)
+	seen := make(map[string]bool)
+	for _, l := range lines {
+		c, ok := specs[l.location.Line]
+		if !ok || !l.added {
+			continue
+		}
+		if key := c.name + "@" + c.version; !seen[key] {
+			seen[key] = true
+			found[l.location.Line] = c
+		}
+	}
+	return found
+}
+
+// lockedGems returns the version each gem of a Gemfile.lock was resolved to
+func lockedGems(content string) map[string]string {
+	var lines []patchLine
+	for i, text := range strings.Split(content, "\n") {
+		lines = append(lines, patchLine{location: changeLocation{Line: int64(i + 1)}, text: strings.TrimSuffix(text, "\r")})
+	}
+
+	locked := make(map[string]string)
+	for _, c := range gemLockSpecs(lines) {
+		locked[c.name] = c.version
+	}
+	return locked
+}
+
+// gemScanner finds the gems declared by a Gemfile, with gem, or a gemspec, with add_dependency and its variants.
+// A gem is evaluated at the version the lockfile resolved it to, or the version it is pinned to if the lockfile isn't available.
+func gemScanner(re *regexp.Regexp, locked map[string]string) func(lines map[changeLocation]string) (map[changeLocation]component, error) {
+	return func(lines map[changeLocation]string) (map[changeLocation]component, error) {
+		components := make(map[changeLocation]component)
+		for loc, line := range lines {
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			requirements, ok := gemRequirements(m[2])
+			if !ok {
+				continue
+			}
+
+			version, ok := locked[m[1]]
+			if !ok {
+				if version, ok = gemPinnedVersion(requirements); !ok {
+					continue
+				}
+			}
+			components[loc] = component{format: "ruby", name: m[1], version: version}
+		}
+		return components, nil
+	}
+}
+
+// gemLockfileFor returns the name of the lockfile bundler writes for a Gemfile, gems.rb or gemspec
+func gemLockfileFor(filename string) string {
+	lockfile := "Gemfile.lock"
+	if path.Base(filename) == "gems.rb" {
+		lockfile = "gems.locked"
+	}
+	return path.Join(path.Dir(filename), lockfile)
+}
+
+// rubyManifestParser creates the parser of a Gemfile or gemspec, which reads the lockfile next to it to know the versions of gems with a range
+func rubyManifestParser(re *regexp.Regexp) manifestParser {
+	return func(f changedFile, src manifestSource) (map[changeLocation]component, error) {
+		locked := make(map[string]string)
+		if src.content != nil {
+			lockfile := gemLockfileFor(f.Filename)
+			if content, err := src.content(lockfile); err == nil {
+				locked = lockedGems(content)
+			} else {
+				log.Printf("TRACE: could not get content of %s, only reviewing pinned gems: %v\n", lockfile, err)
+			}
+		}
+		return fromLineAdditions(gemScanner(re, locked))(f, src)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const testGemfile = `source "https://rubygems.org"
+
+gemspec
+
+gem "rails", "~> 6.1.4"
+gem 'pg', '1.2.3', require: false
+gem "puma", ">= 5.0", "< 6", group: :production
+gem("nokogiri", "= 1.12.5")
+gem "bootsnap", require: false
+gem "devise", git: "https://github.com/heartcombo/devise.git"
+gem 'local', path: '../local'
+gem "rspec-rails", group: [:development, :test]`
+
+const testGemfileLock = `GIT
+  remote: https://github.com/heartcombo/devise.git
+  revision: 0cd72a56f984ef7b6dd7e3c5e9ae6cc8b4b8a1e0
+  specs:
+    devise (4.8.0)
+
+GEM
+  remote: https://rubygems.org/
+  specs:
+    bootsnap (1.9.1)
+      msgpack (~> 1.0)
+    nokogiri (1.12.5-x86_64-linux)
+      racc (~> 1.4)
+    nokogiri (1.12.5-arm64-darwin)
+      racc (~> 1.4)
+    pg (1.2.3)
+    puma (5.5.2)
+    rails (6.1.4.1)
+    rspec-rails (5.0.2)
+
+PLATFORMS
+  x86_64-linux
+
+DEPENDENCIES
+  rails (~> 6.1.4)
+
+BUNDLED WITH
+   2.2.22`
+
+const testGemspec = `Gem::Specification.new do |spec|
+  spec.name    = "example"
+  spec.version = "0.1.0"
+
+  spec.add_dependency "activesupport", ">= 6.0"
+  spec.add_runtime_dependency('faraday', '1.8.0')
+  spec.add_development_dependency "rspec", "~> 3.10"
+end`
+
+func Test_rubyManifests(t *testing.T) {
+	ruby := func(name, version string) component {
+		return component{format: "ruby", name: name, version: version}
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		lockfile string
+		added    []int
+		want     map[int64]component
+	}{
+		{
+			"Gemfile without lockfile",
+			"Gemfile", testGemfile, "",
+			[]int{5, 6, 7, 8, 9, 10, 11, 12},
+			map[int64]component{
+				6: ruby("pg", "1.2.3"),
+				8: ruby("nokogiri", "1.12.5"),
+			},
+		},
+		{
+			"Gemfile with lockfile",
+			"app/Gemfile", testGemfile, testGemfileLock,
+			[]int{5, 6, 7, 8, 9, 10, 11, 12},
+			map[int64]component{
+				5:  ruby("rails", "6.1.4.1"),
+				6:  ruby("pg", "1.2.3"),
+				7:  ruby("puma", "5.5.2"),
+				8:  ruby("nokogiri", "1.12.5"),
+				9:  ruby("bootsnap", "1.9.1"),
+				12: ruby("rspec-rails", "5.0.2"),
+			},
+		},
+		{
+			"gemspec",
+			"example.gemspec", testGemspec, "",
+			[]int{5, 6, 7},
+			map[int64]component{6: ruby("faraday", "1.8.0")},
+		},
+		{
+			"Gemfile.lock",
+			"Gemfile.lock", testGemfileLock, "",
+			[]int{5, 10, 11, 12, 13, 14, 15, 17, 25},
+			map[int64]component{
+				10: ruby("bootsnap", "1.9.1"),
+				12: component{format: "ruby", name: "nokogiri", version: "1.12.5", kind: "x86_64-linux"},
+				17: ruby("puma", "5.5.2"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := func(filename string) (string, error) {
+				switch {
+				case filename == tt.filename:
+					return tt.content, nil
+				case tt.lockfile != "" && filename == gemLockfileFor(tt.filename):
+					return tt.lockfile, nil
+				}
+				return "", errors.New("not found")
+			}
+			checkAddedComponents(t, tt.filename, tt.content, tt.added, manifestSource{content: content}, tt.want)
+		})
+	}
+}
+
+func Test_gemPurl(t *testing.T) {
+	tests := []struct {
+		c    component
+		want string
+	}{
+		{component{format: "ruby", name: "rails", version: "6.1.4.1"}, "pkg:gem/rails@6.1.4.1?platform=ruby"},
+		{component{format: "ruby", name: "nokogiri", version: "1.12.5", kind: "java"}, "pkg:gem/nokogiri@1.12.5?platform=java"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.c.purl(); got != tt.want {
+				t.Errorf("purl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
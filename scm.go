@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scmHost holds the settings for a Github or Gitlab server along with the defaults for its repositories
+type scmHost struct {
+	repoConfig
+
+	// APIURL overrides the API base URL derived from the hostname, e.g. https://gitlab.example.com/gitlab/api/v4
+	APIURL string `json:"api_url,omitempty"`
+	// CABundle is the path to a PEM file of certificate authorities to trust in addition to the system ones
+	CABundle string `json:"ca_bundle,omitempty"`
+	// GithubApp is the app to authenticate as on this host, e.g. a Github Enterprise server. The top-level app is only used for github.com.
+	GithubApp githubApp `json:"github_app,omitempty"`
+}
+
+// scmConnection holds what is needed to call a Github or Gitlab server's API
+type scmConnection struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+// webhookRepository identifies the repository a webhook event was sent for
+type webhookRepository struct {
+	// kind is either github or gitlab
+	host, name, kind string
+	// installationID is set when the event was sent by a Github App installation
+	installationID int64
+}
+
+// repositoryFromPayload finds the Github repository or Gitlab project a webhook event is for and the server hosting it
+func repositoryFromPayload(payload []byte) (webhookRepository, error) {
+	var event struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+			HTMLURL  string `json:"html_url"`
+		} `json:"repository"`
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+			WebURL            string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return webhookRepository{}, fmt.Errorf("could not unmarshal payload as json: %v", err)
+	}
+
+	hostname := func(rawurl string) string {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return ""
+		}
+		return u.Host
+	}
+
+	switch {
+	case event.Repository.FullName != "":
+		return webhookRepository{
+			host:           hostname(event.Repository.HTMLURL),
+			name:           event.Repository.FullName,
+			kind:           "github",
+			installationID: event.Installation.ID,
+		}, nil
+	case event.Project.PathWithNamespace != "":
+		return webhookRepository{
+			host: hostname(event.Project.WebURL),
+			name: event.Project.PathWithNamespace,
+			kind: "gitlab",
+		}, nil
+	}
+
+	return webhookRepository{}, errors.New("could not find repository in webhook payload")
+}
+
+// publicAPIURLs are the APIs of github.com and gitlab.com, which are trusted with credentials without being listed under hosts
+var publicAPIURLs = map[string]string{
+	"github.com": "https://api.github.com",
+	"gitlab.com": "https://gitlab.com/api/v4",
+}
+
+// trustsHost checks whether credentials may be sent to a host, i.e. it is listed under hosts or is github.com or gitlab.com.
+// The host named by a webhook payload is only used to look up its configuration, as anyone can send a payload naming any host.
+func (c config) trustsHost(host string) bool {
+	_, configured := c.Hosts[host]
+	_, public := publicAPIURLs[host]
+	return configured || public
+}
+
+// apiURLFor returns the API base URL of the server hosting the repository, which is built from the configuration rather than the payload.
+// Hosts listed under hosts use their api_url or else the API at the root of the host, e.g. https://ghe.example.com/api/v3.
+func (c config) apiURLFor(repo webhookRepository) (string, error) {
+	if host, ok := c.Hosts[repo.host]; ok {
+		switch {
+		case host.APIURL != "":
+			return host.APIURL, nil
+		case repo.kind == "gitlab":
+			return "https://" + repo.host + "/api/v4", nil
+		default:
+			return "https://" + repo.host + "/api/v3", nil
+		}
+	}
+	if apiURL, ok := publicAPIURLs[repo.host]; ok {
+		return apiURL, nil
+	}
+	return "", fmt.Errorf("host %q is not configured under hosts", repo.host)
+}
+
+var (
+	httpClientsMu sync.Mutex
+	httpClients   = make(map[string]*http.Client)
+)
+
+// httpClientFor returns a client which trusts the certificate authorities in the given bundle as well as the system's
+func httpClientFor(caBundle string) (*http.Client, error) {
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	if client, ok := httpClients[caBundle]; ok {
+		return client, nil
+	}
+
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+	}
+
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.Transport = transport
+	}
+
+	httpClients[caBundle] = client
+	return client, nil
+}
+
+// newSCMConnection creates a connection to the API of the server hosting the repository
+func newSCMConnection(cfg config, repo webhookRepository, token string) (scmConnection, error) {
+	host := cfg.Hosts[repo.host]
+
+	apiURL, err := cfg.apiURLFor(repo)
+	if err != nil {
+		return scmConnection{}, err
+	}
+
+	client, err := httpClientFor(host.CABundle)
+	if err != nil {
+		return scmConnection{}, err
+	}
+
+	scm := scmConnection{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  token,
+		client: client,
+	}
+
+	// Comment as the Github App's bot rather than the owner of a personal access token.
+	// The top-level app belongs to github.com, so other hosts only use their own and never sign with its key.
+	app := host.GithubApp
+	if repo.host == "github.com" && !app.configured() {
+		app = cfg.GithubApp
+	}
+	if app.configured() && repo.installationID != 0 {
+		scm.token, err = app.installationToken(client, scm.apiURL, repo.installationID)
+		if err != nil {
+			return scmConnection{}, fmt.Errorf("could not authenticate as Github App installation %d: %v", repo.installationID, err)
+		}
+	}
+
+	return scm, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_repositoryFromPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    webhookRepository
+		wantErr bool
+	}{
+		{
+			"github.com",
+			`{"repository":{"full_name":"owner/repo","html_url":"https://github.com/owner/repo","url":"https://api.github.com/repos/owner/repo"}}`,
+			webhookRepository{host: "github.com", name: "owner/repo", kind: "github"},
+			false,
+		},
+		{
+			"github enterprise",
+			`{"repository":{"full_name":"owner/repo","html_url":"https://ghe.example.com/owner/repo","url":"https://ghe.example.com/api/v3/repos/owner/repo"}}`,
+			webhookRepository{host: "ghe.example.com", name: "owner/repo", kind: "github"},
+			false,
+		},
+		{
+			"gitlab.com",
+			`{"object_kind":"merge_request","project":{"path_with_namespace":"group/sub/project","web_url":"https://gitlab.com/group/sub/project"},"repository":{"name":"project"}}`,
+			webhookRepository{host: "gitlab.com", name: "group/sub/project", kind: "gitlab"},
+			false,
+		},
+		{
+			"self-hosted gitlab under a path",
+			`{"object_kind":"merge_request","project":{"path_with_namespace":"group/project","web_url":"https://example.com/gitlab/group/project"}}`,
+			webhookRepository{host: "example.com", name: "group/project", kind: "gitlab"},
+			false,
+		},
+		{"no repository", `{}`, webhookRepository{}, true},
+		{"not json", `nope`, webhookRepository{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repositoryFromPayload([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("repositoryFromPayload() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repositoryFromPayload() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_config_forRepository_hosts(t *testing.T) {
+	cfg := config{
+		repoConfig: repoConfig{IQURL: "http://iq", Token: "default"},
+		Hosts: map[string]scmHost{
+			"gitlab.example.com": {repoConfig: repoConfig{Token: "onprem"}, APIURL: "https://gitlab.internal/api/v4"},
+		},
+		Repositories: map[string]repoConfig{
+			"group/project":                    {IQApp: "app"},
+			"gitlab.example.com/group/project": {IQApp: "onprem-app"},
+		},
+	}
+
+	tests := []struct {
+		host, name string
+		want       repoConfig
+	}{
+		{"gitlab.com", "group/project", repoConfig{IQURL: "http://iq", Token: "default", IQApp: "app"}},
+		{"gitlab.example.com", "group/project", repoConfig{IQURL: "http://iq", Token: "onprem", IQApp: "onprem-app"}},
+		{"gitlab.example.com", "group/other", repoConfig{IQURL: "http://iq", Token: "onprem"}},
+		{"attacker.example.com", "group/project", repoConfig{IQURL: "http://iq", IQApp: "app"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+"/"+tt.name, func(t *testing.T) {
+			if got := cfg.forRepository(tt.host, tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forRepository() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	scm, err := newSCMConnection(cfg, webhookRepository{host: "gitlab.example.com", kind: "gitlab"}, "onprem")
+	if err != nil {
+		t.Fatalf("newSCMConnection() error = %v", err)
+	}
+	if scm.apiURL != "https://gitlab.internal/api/v4" {
+		t.Errorf("newSCMConnection() apiURL = %s, want the configured override", scm.apiURL)
+	}
+
+	if _, err := newSCMConnection(cfg, webhookRepository{host: "attacker.example.com", kind: "gitlab"}, "default"); err == nil {
+		t.Error("newSCMConnection() expected error for a host which isn't configured")
+	}
+}
+
+func Test_config_apiURLFor(t *testing.T) {
+	cfg := config{
+		Hosts: map[string]scmHost{
+			"ghe.example.com":    {},
+			"gitlab.example.com": {},
+			"example.com":        {APIURL: "https://example.com/gitlab/api/v4"},
+		},
+	}
+
+	tests := []struct {
+		repo    webhookRepository
+		want    string
+		wantErr bool
+	}{
+		{webhookRepository{host: "github.com", kind: "github"}, "https://api.github.com", false},
+		{webhookRepository{host: "gitlab.com", kind: "gitlab"}, "https://gitlab.com/api/v4", false},
+		{webhookRepository{host: "ghe.example.com", kind: "github"}, "https://ghe.example.com/api/v3", false},
+		{webhookRepository{host: "gitlab.example.com", kind: "gitlab"}, "https://gitlab.example.com/api/v4", false},
+		{webhookRepository{host: "example.com", kind: "gitlab"}, "https://example.com/gitlab/api/v4", false},
+		{webhookRepository{host: "attacker.example.com", kind: "github"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo.host, func(t *testing.T) {
+			got, err := cfg.apiURLFor(tt.repo)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("apiURLFor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("apiURLFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_httpClientFor(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(bundle, pemEncodeCertificate(srv.Certificate().Raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := httpClientFor(bundle)
+	if err != nil {
+		t.Fatalf("httpClientFor() error = %v", err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("could not connect with custom CA bundle: %v", err)
+	}
+	resp.Body.Close()
+
+	notPEM := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := httpClientFor(notPEM); err == nil {
+		t.Error("httpClientFor() expected error for bundle without certificates")
+	}
+}
+
+func Test_glreq_apiURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gitlab/api/v4/projects/1/merge_requests/2" {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		if r.Header.Get("PRIVATE-TOKEN") != "token" {
+			t.Errorf("did not send token")
+		}
+		w.Write([]byte(`{"iid":2,"project_id":1}`))
+	}))
+	defer srv.Close()
+
+	mr, err := getMergeRequest(scmConnection{apiURL: srv.URL + "/gitlab/api/v4", token: "token", client: srv.Client()}, 1, 2)
+	if err != nil {
+		t.Fatalf("getMergeRequest() error = %v", err)
+	}
+	if mr.Iid != 2 {
+		t.Errorf("getMergeRequest() iid = %d, want 2", mr.Iid)
+	}
+}
+
+func Test_githubAPIURL(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/api/v3/repos/owner/repo/pulls/1/files" {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "token ghe-token" {
+			t.Errorf("did not send token")
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	// The payload's API URLs point elsewhere, which must not be called with the token
+	payload := []byte(`{"number":1,"pull_request":{"number":1,"url":"https://attacker.example.com/pulls/1"},
+		"repository":{"full_name":"owner/repo","html_url":"https://ghe.example.com/owner/repo","url":"https://attacker.example.com/repos/owner/repo"}}`)
+	cfg := config{Hosts: map[string]scmHost{"ghe.example.com": {APIURL: srv.URL + "/api/v3"}}}
+
+	webhookRepo, err := repositoryFromPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scm, err := newSCMConnection(cfg, webhookRepo, "ghe-token")
+	if err != nil {
+		t.Fatalf("newSCMConnection() error = %v", err)
+	}
+
+	var pull GithubPullRequest
+	if err := json.Unmarshal(payload, &pull); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getPullRequestFiles(scm, pull); err != nil {
+		t.Fatalf("getPullRequestFiles() error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("configured api_url was called %d times, want 1", requests)
+	}
+}
+
+func pemEncodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
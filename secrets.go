@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// secretStore retrieves the value of a named secret
+type secretStore interface {
+	GetSecret(name string) (string, error)
+}
+
+// fileSecretStore reads secrets from files in a directory, one file per secret.
+// Useful for local development and tests, or with secrets mounted into a container.
+type fileSecretStore struct {
+	dir string
+}
+
+func (s fileSecretStore) GetSecret(name string) (string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+type awsSecretsManagerStore struct {
+	client secretsmanageriface.SecretsManagerAPI
+}
+
+func (s awsSecretsManagerStore) GetSecret(name string) (string, error) {
+	out, err := s.client.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %s does not have a string value", name)
+	}
+	return *out.SecretString, nil
+}
+
+type awsParameterStore struct {
+	client ssmiface.SSMAPI
+}
+
+func (s awsParameterStore) GetSecret(name string) (string, error) {
+	out, err := s.client.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// newSecretStore creates the store for the given source: "secretsmanager", "ssm" or "file"
+func newSecretStore(source string) (secretStore, error) {
+	switch source {
+	case "file":
+		return fileSecretStore{dir: envOrDefault("CONFIG_SECRETS_DIR", ".")}, nil
+	case "secretsmanager", "ssm":
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("could not create AWS session: %v", err)
+		}
+		if source == "ssm" {
+			return awsParameterStore{client: ssm.New(sess)}, nil
+		}
+		return awsSecretsManagerStore{client: secretsmanager.New(sess)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported secret source: %s", source)
+	}
+}
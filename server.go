@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// flattenValues keeps the first value of each key, matching what API Gateway hands to the lambda
+func flattenValues(values map[string][]string) map[string]string {
+	flat := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			flat[k] = v[0]
+		}
+	}
+	return flat
+}
+
+// maxWebhookBodySize is the largest webhook payload accepted, which is the most Github sends
+const maxWebhookBodySize = 25 << 20
+
+func newWebhookHandler(cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST requests are supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+		if err != nil && len(body) >= maxWebhookBodySize {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		status, message, _ := handleWebhookEvent(cfg, flattenValues(r.Header), flattenValues(r.URL.Query()), body)
+		w.WriteHeader(status)
+		fmt.Fprint(w, message)
+	}
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+func newServeMux(cfg config) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/", newWebhookHandler(cfg))
+	return mux
+}
+
+// serve runs the webhook handlers as a standalone HTTP server until it receives SIGINT or SIGTERM
+func serve(cfg config, addr string, shutdownTimeout time.Duration) error {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      newServeMux(cfg),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 5 * time.Minute,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("INFO: listening for webhooks on %s\n", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("could not start server: %v", err)
+	case sig := <-stop:
+		log.Printf("INFO: received %s, shutting down\n", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("could not shut down server gracefully: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_newServeMux(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		headers    map[string]string
+		cfg        config
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"health",
+			http.MethodGet,
+			"/healthz",
+			nil,
+			config{},
+			http.StatusOK,
+			"ok",
+		},
+		{
+			"github ping",
+			http.MethodPost,
+			"/",
+			map[string]string{"X-GitHub-Event": "ping"},
+			config{AllowUnverifiedWebhooks: true},
+			http.StatusOK,
+			"Acknowledging ping",
+		},
+		{
+			"github without secret",
+			http.MethodPost,
+			"/",
+			map[string]string{"X-GitHub-Event": "pull_request"},
+			config{},
+			http.StatusUnauthorized,
+			"No Github webhook secret configured",
+		},
+		{
+			"github forged signature",
+			http.MethodPost,
+			"/",
+			map[string]string{"X-GitHub-Event": "pull_request", "X-Hub-Signature-256": "sha256=00"},
+			config{GithubWebhookSecret: "secret"},
+			http.StatusUnauthorized,
+			"Could not verify Github webhook signature",
+		},
+		{
+			"gitlab without secret",
+			http.MethodPost,
+			"/",
+			map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": "anything"},
+			config{},
+			http.StatusUnauthorized,
+			"No Gitlab webhook secret configured",
+		},
+		{
+			"gitlab wrong token",
+			http.MethodPost,
+			"/",
+			map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": "wrong"},
+			config{GitlabWebhookSecrets: gitlabWebhookSecrets{"*": {"secret"}}},
+			http.StatusUnauthorized,
+			"Could not verify Gitlab webhook token",
+		},
+		{
+			"not a webhook",
+			http.MethodPost,
+			"/",
+			nil,
+			config{},
+			http.StatusBadRequest,
+			"Did not receive a valid Gitlab webhook",
+		},
+		{
+			"wrong method",
+			http.MethodGet,
+			"/",
+			nil,
+			config{},
+			http.StatusMethodNotAllowed,
+			"Only POST requests are supported\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("{}"))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			newServeMux(tt.cfg).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_newWebhookHandler_bodyTooLarge(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat(" ", maxWebhookBodySize+1)))
+	req.Header.Set("X-GitHub-Event", "ping")
+	rec := httptest.NewRecorder()
+
+	newWebhookHandler(config{}).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}